@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var batchWorkers = 4
+var batchLimit = 100
+
+type batchRequest struct {
+	URLs      []string `json:"urls"`
+	Platforms []string `json:"platforms"`
+}
+
+type batchResponse struct {
+	Results map[string]*collector.Result `json:"results"`
+	Errors  map[string]string            `json:"errors"`
+}
+
+// batchHandler collects stats for URLs posted either as JSON array or as
+// object with "urls" and "platforms".
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	start := time.Now()
+
+	if r.Method != "POST" {
+		writeJSONError(w, "Only POST is supported.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, err := readBatchRequest(w, r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := statsOptions(r)
+	opts.Platforms = request.Platforms
+
+	response := batchResponse{
+		Results: map[string]*collector.Result{},
+		Errors:  map[string]string{},
+	}
+
+	var mu sync.Mutex
+	forEachURL(r.Context(), request.URLs, batchWorkers, func(i int, url string) {
+		result, err := collectBatchURL(r.Context(), url, opts)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			response.Errors[url] = err.Error()
+		} else {
+			response.Results[url] = result
+		}
+	})
+
+	if r.Context().Err() != nil {
+		collector.LoggerFrom(r.Context()).Warn("Cancelled batch", "urls", len(request.URLs))
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		writeJSONError(w, "Error compiling JSON.", http.StatusInternalServerError)
+		return
+	}
+
+	collector.LoggerFrom(r.Context()).Info("Compiled batch", "urls", len(request.URLs), "duration", time.Now().Sub(start).Seconds())
+	w.Write(body)
+}
+
+func readBatchRequest(w http.ResponseWriter, r *http.Request) (batchRequest, error) {
+	request := batchRequest{}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return request, err
+	}
+
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		err = json.Unmarshal(body, &request.URLs)
+	} else {
+		err = json.Unmarshal(body, &request)
+	}
+
+	if err != nil {
+		return request, errors.New("Invalid JSON.")
+	}
+
+	urls, seen := []string{}, map[string]bool{}
+	for _, url := range request.URLs {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	request.URLs = urls
+
+	if len(request.URLs) == 0 {
+		return request, errors.New("Missing required URLs.")
+	}
+
+	if len(request.URLs) > batchLimit {
+		return request, errors.New("Too many URLs.")
+	}
+
+	return request, nil
+}
+
+func collectBatchURL(ctx context.Context, url string, opts collector.Options) (*collector.Result, error) {
+	if !isHTTPURL(url) {
+		return nil, errors.New("Invalid URL.")
+	}
+
+	result, _, err := collectStats(ctx, url, opts)
+	return result, err
+}
+
+// forEachURL calls fn for every URL using at most workers goroutines. It
+// stops handing out URLs once ctx is done.
+func forEachURL(ctx context.Context, urls []string, workers int, fn func(i int, url string)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i, urls[i])
+			}
+		}()
+	}
+
+	for i := range urls {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	close(indexes)
+	wg.Wait()
+}
+
+// isHTTPURL is true for absolute http and https URLs.
+func isHTTPURL(url string) bool {
+	parsed, err := neturl.Parse(url)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
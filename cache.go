@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var cache *collector.Cache
+var cacheTTL time.Duration
+var cacheStale time.Duration
+var cacheSize = 1000
+var cacheDir = ""
+var cachePlatformTTL = ""
+var cacheErrorTTL = 30 * time.Second
+
+// setupCache creates cache of /stats results when cache TTL is set.
+func setupCache() error {
+	if cacheTTL <= 0 {
+		return nil
+	}
+
+	var backend collector.CacheBackend = collector.NewMemoryCache(cacheSize)
+	if cacheDir != "" {
+		diskCache, err := collector.NewDiskCache(cacheDir)
+		if err != nil {
+			return err
+		}
+		backend = diskCache
+	}
+
+	platformTTL, err := parsePlatformTTL(cachePlatformTTL)
+	if err != nil {
+		return err
+	}
+
+	cache = collector.NewCache(backend, cacheTTL)
+	cache.PlatformTTL = platformTTL
+	cache.StaleWhileRevalidate = cacheStale
+	cache.ErrorTTL = cacheErrorTTL
+	return nil
+}
+
+// parsePlatformTTL parses list of TTLs such as "facebook=10m,reddit=1m".
+func parsePlatformTTL(value string) (map[string]time.Duration, error) {
+	platformTTL := map[string]time.Duration{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid platform TTL " + pair)
+		}
+
+		ttl, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		platformTTL[parts[0]] = ttl
+	}
+
+	return platformTTL, nil
+}
+
+func writeCacheHeaders(w http.ResponseWriter, status collector.CacheStatus) {
+	maxAge := status.TTL - status.Age
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d",
+		int(maxAge.Seconds()), int(cacheStale.Seconds())))
+	w.Header().Set("Age", fmt.Sprintf("%d", int(status.Age.Seconds())))
+
+	switch {
+	case status.Stale:
+		w.Header().Set("X-Cache", "STALE")
+	case status.Hit:
+		w.Header().Set("X-Cache", "HIT")
+	default:
+		w.Header().Set("X-Cache", "MISS")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var outputFormat = "json"
+var inputFile = ""
+var concurrency = 1
+var outputOrder = "input"
+var canonicalize = false
+var queryVariants = false
+
+// runCLI collects stats for URLs given by flags and prints them.
+func runCLI() error {
+	cliURLs = []string{}
+	for _, url := range strings.Split(cliURL, ",") {
+		if url != "" {
+			cliURLs = append(cliURLs, url)
+		}
+	}
+
+	if inputFile != "" {
+		urls, err := readInputFile(inputFile)
+		if err != nil {
+			return err
+		}
+		cliURLs = append(cliURLs, urls...)
+	}
+
+	if len(cliURLs) == 0 {
+		return errors.New("Missing required URL.")
+	}
+
+	cliPlatforms = strings.Split(cliPlatform, ",")
+	if len(cliPlatforms) == 0 ||
+		(len(cliPlatforms) == 1 && cliPlatforms[0] == "") {
+		cliPlatforms = nil
+	}
+
+	if outputOrder != "input" && outputOrder != "completion" {
+		return errors.New("Unknown output order " + outputOrder)
+	}
+
+	writer, err := newResultWriter(os.Stdout, outputFormat, columnPlatforms(cliPlatforms))
+	if err != nil {
+		return err
+	}
+
+	opts := collector.Options{
+		Platforms:          cliPlatforms,
+		Proxy:              proxy,
+		InsecureSkipVerify: insecure,
+		CAFile:             caFile,
+		Canonicalize:       canonicalize,
+		QueryVariants:      queryVariants,
+		MaxRedirects:       redirectsLimit(),
+		StripParams:        stripParamList(),
+		Retry:              retryPolicy,
+		PlatformRetry:      platformRetry,
+		RateLimiter:        rateLimiter,
+	}
+
+	var mu sync.Mutex
+	var writeErr error
+	results := map[int]*collector.Result{}
+	next := 0
+
+	forEachURL(context.Background(), cliURLs, concurrency, func(i int, url string) {
+		aggregated, _ := collector.Collect(context.Background(), url, opts)
+		recordHistory(aggregated)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if outputOrder == "completion" {
+			if err := writer.Write(url, aggregated); err != nil && writeErr == nil {
+				writeErr = err
+			}
+			return
+		}
+
+		// Results that completed ahead of their turn wait for earlier ones.
+		results[i] = aggregated
+		for results[next] != nil {
+			if err := writer.Write(cliURLs[next], results[next]); err != nil && writeErr == nil {
+				writeErr = err
+			}
+			delete(results, next)
+			next++
+		}
+	})
+
+	if writeErr != nil {
+		return writeErr
+	}
+
+	return writer.Flush()
+}
+
+func readInputFile(path string) ([]string, error) {
+	if path == "-" {
+		return readURLs(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readURLs(file)
+}
+
+// readURLs reads one URL per line. Blank lines and lines starting with #
+// are skipped.
+func readURLs(r io.Reader) ([]string, error) {
+	urls := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		urls = append(urls, line)
+	}
+
+	return urls, scanner.Err()
+}
+
+// columnPlatforms returns selected platforms or all registered ones.
+func columnPlatforms(selected []string) []string {
+	if len(selected) > 0 {
+		return selected
+	}
+
+	platforms := []string{}
+	for _, provider := range collector.DefaultRegistry.Providers() {
+		platforms = append(platforms, provider.Name())
+	}
+
+	return platforms
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var history *collector.HistoryStore
+var historyDir = ""
+var historySince = ""
+
+// setupHistory opens history store when history directory is set.
+func setupHistory() error {
+	if historyDir == "" {
+		return nil
+	}
+
+	store, err := collector.NewHistoryStore(historyDir)
+	if err != nil {
+		return err
+	}
+
+	store.StripParams = stripParamList()
+	history = store
+	return nil
+}
+
+// recordHistory keeps snapshot of freshly collected result.
+func recordHistory(result *collector.Result) {
+	if history == nil || result == nil {
+		return
+	}
+
+	if err := history.Record(result); err != nil {
+		logger.Error("Recording history failed", "url", result.URL, "error", err)
+	}
+}
+
+// parseSince reads start of history either as duration before now, such as
+// "24h", or as RFC 3339 time. Empty value means whole history.
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid since " + value)
+	}
+
+	return since, nil
+}
+
+// historyHandler returns time series of counts of URL.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		renderError(w, r, "Missing required URL.", http.StatusBadRequest)
+		return
+	}
+
+	if history == nil {
+		renderError(w, r, "History is not enabled.", http.StatusNotFound)
+		return
+	}
+
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := history.History(url, since)
+	if err != nil {
+		collector.LoggerFrom(r.Context()).Error("Reading history failed", "url", url, "error", err)
+		renderError(w, r, "Error reading history.", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, r, series, http.StatusOK)
+}
+
+// runHistory prints time series of URL given by flags.
+func runHistory() error {
+	if cliURL == "" {
+		return errors.New("Missing required URL.")
+	}
+
+	if history == nil {
+		return errors.New("Missing required history directory.")
+	}
+
+	since, err := parseSince(historySince)
+	if err != nil {
+		return err
+	}
+
+	series, err := history.History(cliURL, since)
+	if err != nil {
+		return err
+	}
+
+	return writeHistory(os.Stdout, outputFormat, series)
+}
+
+// writeHistory writes time series in one of output formats. CSV and table
+// have a count and delta column for each platform.
+func writeHistory(w io.Writer, format string, series *collector.History) error {
+	switch format {
+	case "json":
+		body, err := json.MarshalIndent(series, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(body))
+		return err
+	case "ndjson":
+		for _, point := range series.Points {
+			body, err := json.Marshal(point)
+			if err != nil {
+				return err
+			}
+
+			if _, err := fmt.Fprintln(w, string(body)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var rows rowWriter
+	switch format {
+	case "csv":
+		rows = csv.NewWriter(w)
+	case "table":
+		rows = tabWriter{tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)}
+	default:
+		return errors.New("Unknown output format " + format)
+	}
+
+	platforms := historyPlatforms(series)
+	header := []string{"time"}
+	for _, name := range platforms {
+		header = append(header, name, name+"_delta")
+	}
+
+	if err := rows.Write(append(header, "total", "total_delta")); err != nil {
+		return err
+	}
+
+	for _, point := range series.Points {
+		row := []string{point.Time.Format(time.RFC3339)}
+		for _, name := range platforms {
+			row = append(row, formatCount(point.Counts, name), formatCount(point.Deltas, name))
+		}
+
+		row = append(row, strconv.FormatInt(point.Total, 10), strconv.FormatInt(point.TotalDelta, 10))
+		if err := rows.Write(row); err != nil {
+			return err
+		}
+	}
+
+	rows.Flush()
+	return rows.Error()
+}
+
+// historyPlatforms returns sorted names of platforms present in series.
+func historyPlatforms(series *collector.History) []string {
+	seen := map[string]bool{}
+	platforms := []string{}
+	for _, point := range series.Points {
+		for name := range point.Counts {
+			if !seen[name] {
+				seen[name] = true
+				platforms = append(platforms, name)
+			}
+		}
+	}
+
+	sort.Strings(platforms)
+	return platforms
+}
+
+func formatCount(counts map[string]int64, name string) string {
+	if count, ok := counts[name]; ok {
+		return strconv.FormatInt(count, 10)
+	}
+
+	return ""
+}
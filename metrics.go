@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+// latencyBuckets are upper bounds of latency histograms in seconds.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var metrics = newServerMetrics()
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.buckets == nil {
+		h.buckets = make([]uint64, len(latencyBuckets))
+	}
+
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+
+	h.count++
+	h.sum += seconds
+}
+
+// serverMetrics counts requests of server and results of collections and
+// renders them in Prometheus text format.
+type serverMetrics struct {
+	mu                sync.Mutex
+	inFlight          int64
+	requests          map[string]map[string]uint64
+	platformRequests  map[string]uint64
+	platformErrors    map[string]map[string]uint64
+	platformFetch     map[string]*histogram
+	platformCompleted map[string]*histogram
+	originFetch       *histogram
+	originErrors      uint64
+	cache             map[string]uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:          map[string]map[string]uint64{},
+		platformRequests:  map[string]uint64{},
+		platformErrors:    map[string]map[string]uint64{},
+		platformFetch:     map[string]*histogram{},
+		platformCompleted: map[string]*histogram{},
+		originFetch:       &histogram{},
+		cache:             map[string]uint64{},
+	}
+}
+
+// observeResult counts platform requests, errors and latencies of freshly
+// collected result.
+func (m *serverMetrics) observeResult(result *collector.Result) {
+	if result == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, platform := range result.Platforms {
+		m.platformRequests[name]++
+		if platform.Error != nil {
+			if m.platformErrors[name] == nil {
+				m.platformErrors[name] = map[string]uint64{}
+			}
+			m.platformErrors[name][errorClass(platform.Error)]++
+		} else {
+			if m.platformFetch[name] == nil {
+				m.platformFetch[name] = &histogram{}
+			}
+			m.platformFetch[name].observe(platform.FetchedIn.Seconds())
+		}
+
+		if m.platformCompleted[name] == nil {
+			m.platformCompleted[name] = &histogram{}
+		}
+		m.platformCompleted[name].observe(platform.CompletedIn.Seconds())
+	}
+
+	if result.Origin != nil && result.Origin.FetchedIn > 0 {
+		m.originFetch.observe(result.Origin.FetchedIn.Seconds())
+	} else {
+		m.originErrors++
+	}
+}
+
+// observeCache counts how result was served from cache.
+func (m *serverMetrics) observeCache(status collector.CacheStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case status.Stale:
+		m.cache["stale"]++
+	case status.Hit:
+		m.cache["hit"]++
+	default:
+		m.cache["miss"]++
+	}
+}
+
+// errorClass groups platform errors for metrics by their kind.
+func errorClass(err error) string {
+	var platformError *collector.PlatformError
+	if errors.As(err, &platformError) {
+		return platformError.Kind
+	}
+
+	return "other"
+}
+
+// instrument counts requests of handler by status code and requests that
+// are in flight.
+func instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.mu.Lock()
+		metrics.inFlight++
+		metrics.mu.Unlock()
+
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		defer func() {
+			metrics.mu.Lock()
+			defer metrics.mu.Unlock()
+
+			metrics.inFlight--
+			if metrics.requests[name] == nil {
+				metrics.requests[name] = map[string]uint64{}
+			}
+			metrics.requests[name][fmt.Sprint(recorder.code)]++
+		}()
+
+		handler(recorder, r)
+	}
+}
+
+// statusRecorder remembers status code written to response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metricsHandler renders metrics in Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w, collector.DefaultTransports.Stats())
+}
+
+func (m *serverMetrics) write(w io.Writer, transport collector.TransportStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "socol_http_requests_in_flight", "gauge", "Requests currently served.")
+	fmt.Fprintf(w, "socol_http_requests_in_flight %d\n", m.inFlight)
+
+	header(w, "socol_http_requests_total", "counter", "Requests served by handler and status code.")
+	for _, handler := range sortedKeys(m.requests) {
+		for _, code := range sortedKeys(m.requests[handler]) {
+			fmt.Fprintf(w, "socol_http_requests_total{handler=%q,code=%q} %d\n",
+				labelValue(handler), code, m.requests[handler][code])
+		}
+	}
+
+	header(w, "socol_platform_requests_total", "counter", "Collections of platform stats.")
+	for _, name := range sortedKeys(m.platformRequests) {
+		fmt.Fprintf(w, "socol_platform_requests_total{platform=%q} %d\n", labelValue(name), m.platformRequests[name])
+	}
+
+	header(w, "socol_platform_errors_total", "counter", "Failed collections of platform stats by class of error.")
+	for _, name := range sortedKeys(m.platformErrors) {
+		for _, class := range sortedKeys(m.platformErrors[name]) {
+			fmt.Fprintf(w, "socol_platform_errors_total{platform=%q,class=%q} %d\n",
+				labelValue(name), class, m.platformErrors[name][class])
+		}
+	}
+
+	header(w, "socol_platform_fetch_seconds", "histogram", "Time until platform stats were fetched.")
+	for _, name := range sortedKeys(m.platformFetch) {
+		writeHistogram(w, "socol_platform_fetch_seconds", fmt.Sprintf("platform=%q,", labelValue(name)), m.platformFetch[name])
+	}
+
+	header(w, "socol_platform_completed_seconds", "histogram", "Time until platform stats were completed or failed.")
+	for _, name := range sortedKeys(m.platformCompleted) {
+		writeHistogram(w, "socol_platform_completed_seconds", fmt.Sprintf("platform=%q,", labelValue(name)), m.platformCompleted[name])
+	}
+
+	header(w, "socol_origin_fetch_seconds", "histogram", "Time until looked up page was fetched.")
+	writeHistogram(w, "socol_origin_fetch_seconds", "", m.originFetch)
+
+	header(w, "socol_origin_errors_total", "counter", "Looked up pages that couldn't be fetched.")
+	fmt.Fprintf(w, "socol_origin_errors_total %d\n", m.originErrors)
+
+	header(w, "socol_cache_requests_total", "counter", "Results served through cache by outcome.")
+	lookups := uint64(0)
+	for _, outcome := range []string{"hit", "stale", "miss"} {
+		fmt.Fprintf(w, "socol_cache_requests_total{result=%q} %d\n", outcome, m.cache[outcome])
+		lookups += m.cache[outcome]
+	}
+
+	header(w, "socol_cache_hit_ratio", "gauge", "Share of results served from cache, fresh or stale.")
+	ratio := 0.0
+	if lookups > 0 {
+		ratio = float64(m.cache["hit"]+m.cache["stale"]) / float64(lookups)
+	}
+	fmt.Fprintf(w, "socol_cache_hit_ratio %g\n", ratio)
+
+	header(w, "socol_transport_requests_total", "counter", "Outbound requests sent over pooled transports.")
+	fmt.Fprintf(w, "socol_transport_requests_total %d\n", transport.Requests)
+	header(w, "socol_transport_connections_total", "counter", "Connections used by outbound requests.")
+	fmt.Fprintf(w, "socol_transport_connections_total{reused=\"false\"} %d\n", transport.NewConnections)
+	fmt.Fprintf(w, "socol_transport_connections_total{reused=\"true\"} %d\n", transport.ReusedConnections)
+}
+
+func header(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(w io.Writer, name string, labels string, h *histogram) {
+	for i, bound := range latencyBuckets {
+		count := uint64(0)
+		if h.buckets != nil {
+			count = h.buckets[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, bound, count)
+	}
+
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// labelValue keeps label values printable with %q as Prometheus expects.
+func labelValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch v := m.(type) {
+	case map[string]uint64:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]uint64:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]*histogram:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
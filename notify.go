@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var notifier *collector.Notifier
+var rulesFile = ""
+var webhookURL = ""
+var webhookSecret = ""
+var webhookDryRun = false
+
+// setupNotifier reads notification rules when rules file is set.
+func setupNotifier() error {
+	if rulesFile == "" {
+		return nil
+	}
+
+	body, err := ioutil.ReadFile(rulesFile)
+	if err != nil {
+		return err
+	}
+
+	rules := []*collector.Rule{}
+	if err := json.Unmarshal(body, &rules); err != nil {
+		return err
+	}
+
+	n, err := collector.NewNotifier(rules, webhookURL)
+	if err != nil {
+		return err
+	}
+
+	n.Secret = webhookSecret
+	n.DryRun = webhookDryRun
+	notifier = n
+	logger.Info("Notifying webhooks", "rules", len(rules), "dry_run", webhookDryRun)
+	return nil
+}
+
+// observeResult counts freshly collected result in metrics, records it to
+// history and checks it against notification rules in background.
+func observeResult(result *collector.Result) {
+	metrics.observeResult(result)
+	recordHistory(result)
+
+	if notifier == nil || result == nil {
+		return
+	}
+
+	at := time.Now()
+	go notifier.Observe(context.Background(), result, at)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+// resultWriter writes results of CLI in one of output formats.
+type resultWriter interface {
+	Write(url string, result *collector.Result) error
+	Flush() error
+}
+
+// newResultWriter creates writer for format. CSV and table have a column
+// for each of platforms.
+func newResultWriter(w io.Writer, format string, platforms []string) (resultWriter, error) {
+	switch format {
+	case "json":
+		return &jsonWriter{w: w, indent: true}, nil
+	case "ndjson":
+		return &jsonWriter{w: w}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w), platforms: platforms}, nil
+	case "table":
+		return &csvWriter{
+			w:         tabWriter{tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)},
+			platforms: platforms,
+		}, nil
+	}
+
+	return nil, errors.New("Unknown output format " + format)
+}
+
+type jsonWriter struct {
+	w      io.Writer
+	indent bool
+}
+
+func (j *jsonWriter) Write(url string, result *collector.Result) error {
+	if j.indent {
+		body, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(j.w, string(body))
+		return err
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	var line map[string]json.RawMessage
+	if err := json.Unmarshal(body, &line); err != nil {
+		return err
+	}
+
+	line["url"], _ = json.Marshal(url)
+	body, err = json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(j.w, string(body))
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	return nil
+}
+
+type rowWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// csvWriter writes one row per URL with count of each platform and total.
+type csvWriter struct {
+	w         rowWriter
+	platforms []string
+	header    bool
+}
+
+func (c *csvWriter) Write(url string, result *collector.Result) error {
+	if !c.header {
+		c.header = true
+		header := append(append([]string{"url"}, c.platforms...), "total")
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+
+	row := []string{url}
+	for _, name := range c.platforms {
+		platform, ok := result.Platforms[name]
+		if !ok || platform.Error != nil {
+			row = append(row, "")
+		} else {
+			row = append(row, strconv.FormatInt(platform.Count, 10))
+		}
+	}
+
+	row = append(row, strconv.FormatInt(result.Meta.Total, 10))
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// tabWriter writes rows as aligned columns.
+type tabWriter struct {
+	w *tabwriter.Writer
+}
+
+func (t tabWriter) Write(record []string) error {
+	for _, field := range record {
+		if _, err := fmt.Fprint(t.w, field, "\t"); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintln(t.w)
+	return err
+}
+
+func (t tabWriter) Flush() {
+	t.w.Flush()
+}
+
+func (t tabWriter) Error() error {
+	return nil
+}
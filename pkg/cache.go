@@ -0,0 +1,391 @@
+package collector
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheEntry is a result kept in cache.
+type CacheEntry struct {
+	URL      string        `json:"url"`
+	Result   *Result       `json:"result"`
+	StoredAt time.Time     `json:"stored_at"`
+	TTL      time.Duration `json:"ttl"`
+}
+
+// CacheBackend stores cache entries under keys.
+type CacheBackend interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+}
+
+// CacheStatus describes how a result was served from cache.
+type CacheStatus struct {
+	// Hit is true when result came from cache.
+	Hit bool
+	// Stale is true when cached result has expired and is being refreshed.
+	Stale bool
+	// Age is time since result was collected.
+	Age time.Duration
+	// TTL is time for which result is fresh.
+	TTL time.Duration
+}
+
+// Cache serves collected results from backend until they expire. Use
+// NewCache to create it.
+type Cache struct {
+	// Backend keeps entries. Defaults to in memory LRU of 1000 entries.
+	Backend CacheBackend
+	// TTL is time for which results are fresh.
+	TTL time.Duration
+	// PlatformTTL overrides TTL for results of platforms with given names.
+	// Results of more platforms are fresh for the shortest of their TTLs.
+	PlatformTTL map[string]time.Duration
+	// StaleWhileRevalidate is time after expiry during which stale result
+	// is served while a fresh one is collected in background.
+	StaleWhileRevalidate time.Duration
+	// ErrorTTL is time for which results with retryable errors, such as
+	// timeouts, are fresh. Defaults to 30 seconds or TTL when shorter,
+	// negative value disables caching of such results.
+	ErrorTTL time.Duration
+
+	mu         sync.Mutex
+	refreshing map[string]bool
+}
+
+// NewCache creates cache with backend and default TTL of results.
+func NewCache(backend CacheBackend, ttl time.Duration) *Cache {
+	if backend == nil {
+		backend = NewMemoryCache(1000)
+	}
+
+	return &Cache{
+		Backend:    backend,
+		TTL:        ttl,
+		refreshing: map[string]bool{},
+	}
+}
+
+// Collect returns cached result for lookupURL or collects it as Collect
+// does and keeps it for later calls.
+func (c *Cache) Collect(ctx context.Context, lookupURL string, opts Options) (*Result, CacheStatus, error) {
+	key := CacheKey(lookupURL, opts)
+	ttl := c.ttl(opts)
+
+	if entry, ok := c.Backend.Get(key); ok {
+		age := time.Since(entry.StoredAt)
+		status := CacheStatus{Hit: true, Age: age, TTL: entry.TTL}
+
+		if age < entry.TTL {
+			return entry.Result, status, nil
+		}
+
+		if age < entry.TTL+c.StaleWhileRevalidate {
+			status.Stale = true
+			c.refresh(key, lookupURL, opts, ttl)
+			return entry.Result, status, nil
+		}
+
+		c.Backend.Delete(key)
+	}
+
+	result, err := Collect(ctx, lookupURL, opts)
+	if err != nil {
+		return result, CacheStatus{TTL: ttl}, err
+	}
+
+	ttl = c.store(key, lookupURL, result, ttl)
+	return result, CacheStatus{TTL: ttl}, nil
+}
+
+// Store keeps result of lookupURL collected outside of cache, e.g. streamed,
+// as Collect would keep it.
+func (c *Cache) Store(lookupURL string, opts Options, result *Result) {
+	c.store(CacheKey(lookupURL, opts), lookupURL, result, c.ttl(opts))
+}
+
+// store keeps result and returns time for which it is fresh. Result with
+// retryable errors isn't kept when ErrorTTL disables caching of such results.
+func (c *Cache) store(key string, lookupURL string, result *Result, ttl time.Duration) time.Duration {
+	if ttl = c.entryTTL(result, ttl); ttl > 0 {
+		c.Backend.Set(key, &CacheEntry{URL: lookupURL, Result: result, StoredAt: time.Now(), TTL: ttl})
+	}
+
+	return ttl
+}
+
+// entryTTL returns ttl of result, shortened to ErrorTTL when platform or
+// origin failed with retryable error.
+func (c *Cache) entryTTL(result *Result, ttl time.Duration) time.Duration {
+	retryable := false
+	for _, err := range result.Errors {
+		var platformError *PlatformError
+		if errors.As(err, &platformError) && platformError.Retryable {
+			retryable = true
+		}
+	}
+
+	if !retryable {
+		return ttl
+	}
+
+	errorTTL := c.ErrorTTL
+	if errorTTL == 0 {
+		errorTTL = 30 * time.Second
+	}
+
+	if errorTTL < 0 {
+		return 0
+	}
+
+	if errorTTL > ttl {
+		errorTTL = ttl
+	}
+
+	return errorTTL
+}
+
+// refresh collects result in background unless it is already being
+// collected.
+func (c *Cache) refresh(key string, lookupURL string, opts Options, ttl time.Duration) {
+	c.mu.Lock()
+	if c.refreshing == nil {
+		c.refreshing = map[string]bool{}
+	}
+
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+
+		// Refresh is bounded even when Deadline of opts is disabled, so it
+		// can't keep key marked as refreshing forever.
+		timeout := opts.deadline()
+		if timeout <= 0 {
+			timeout = 3 * opts.timeout()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		result, err := Collect(ctx, lookupURL, opts)
+		if err != nil {
+			Logger.Warn("Refreshing cached result failed", "url", lookupURL, "error", err)
+			return
+		}
+
+		c.store(key, lookupURL, result, ttl)
+	}()
+}
+
+func (c *Cache) ttl(opts Options) time.Duration {
+	names := opts.Platforms
+	if len(names) == 0 || (len(names) == 1 && names[0] == "") {
+		names = []string{}
+		for _, provider := range opts.registry().Providers() {
+			names = append(names, provider.Name())
+		}
+	}
+
+	ttl := c.TTL
+	for _, name := range names {
+		if platformTTL, ok := c.PlatformTTL[name]; ok && platformTTL < ttl {
+			ttl = platformTTL
+		}
+	}
+
+	return ttl
+}
+
+// CacheKey returns key of results for lookupURL and platforms selected in
+// opts.
+func CacheKey(lookupURL string, opts Options) string {
+	platforms := []string{}
+	for _, name := range opts.Platforms {
+		if name != "" {
+			platforms = append(platforms, name)
+		}
+	}
+
+	sort.Strings(platforms)
+	if len(platforms) == 0 {
+		platforms = []string{"*"}
+	}
+
+	key := Normalize(lookupURL, opts.stripParams())
+	if opts.Canonicalize {
+		key += " canonical"
+	}
+
+	if opts.QueryVariants {
+		key += " variants"
+	}
+
+	return key + " " + strings.Join(platforms, ",")
+}
+
+// MemoryCache is in memory backend that evicts least recently used entries.
+type MemoryCache struct {
+	size    int
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCache creates backend that keeps up to size entries.
+func NewMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get returns entry stored under key.
+func (m *MemoryCache) Get(key string) (*CacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	m.order.MoveToFront(element)
+	return element.Value.(*memoryCacheItem).entry, true
+}
+
+// Set stores entry under key, evicting least recently used entries.
+func (m *MemoryCache) Set(key string, entry *CacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*memoryCacheItem).entry = entry
+		m.order.MoveToFront(element)
+		return
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	for m.size > 0 && m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// Delete removes entry stored under key.
+func (m *MemoryCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		m.order.Remove(element)
+		delete(m.entries, key)
+	}
+}
+
+// Len returns number of stored entries.
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.order.Len()
+}
+
+// DiskCache is backend that keeps entries as JSON files in a directory, so
+// they survive restarts.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache creates backend in dir, creating directory when missing.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &DiskCache{dir: dir}, nil
+}
+
+func (d *DiskCache) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get returns entry stored under key.
+func (d *DiskCache) Get(key string) (*CacheEntry, bool) {
+	body, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &CacheEntry{}
+	if err := json.Unmarshal(body, entry); err != nil || entry.Result == nil {
+		Logger.Warn("Reading cache entry failed", "error", err)
+		return nil, false
+	}
+
+	entry.Result.URL = entry.URL
+	return entry, true
+}
+
+// Set stores entry under key.
+func (d *DiskCache) Set(key string, entry *CacheEntry) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		Logger.Warn("Encoding cache entry failed", "url", entry.URL, "error", err)
+		return
+	}
+
+	file, err := ioutil.TempFile(d.dir, "entry")
+	if err != nil {
+		Logger.Warn("Writing cache entry failed", "url", entry.URL, "error", err)
+		return
+	}
+
+	_, err = file.Write(body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), d.path(key))
+	}
+
+	if err != nil {
+		Logger.Warn("Writing cache entry failed", "url", entry.URL, "error", err)
+		os.Remove(file.Name())
+	}
+}
+
+// Delete removes entry stored under key.
+func (d *DiskCache) Delete(key string) {
+	os.Remove(d.path(key))
+}
@@ -0,0 +1,171 @@
+package collector
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", &CacheEntry{URL: "a"})
+	cache.Set("b", &CacheEntry{URL: "b"})
+	cache.Get("a")
+	cache.Set("c", &CacheEntry{URL: "c"})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("expected %s to be kept", key)
+		}
+	}
+}
+
+func TestCacheServesStaleWhileRevalidating(t *testing.T) {
+	var hits int64
+	handler := testHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/count" {
+			atomic.AddInt64(&hits, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	opts := Options{Registry: NewRegistry(testPlatform("ok", server.URL+"/count"))}
+	cache := NewCache(nil, time.Hour)
+	cache.PlatformTTL = map[string]time.Duration{"ok": 100 * time.Millisecond}
+	cache.StaleWhileRevalidate = time.Hour
+
+	_, status, _ := cache.Collect(context.Background(), server.URL+"/", opts)
+	if status.Hit || status.TTL != 100*time.Millisecond {
+		t.Errorf("expected miss with platform TTL, got %+v", status)
+	}
+
+	result, status, _ := cache.Collect(context.Background(), server.URL, opts)
+	if !status.Hit || status.Stale || result.Meta.Total != 3 {
+		t.Errorf("expected fresh hit, got %+v", status)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	_, status, _ = cache.Collect(context.Background(), server.URL+"/", opts)
+	if !status.Hit || !status.Stale {
+		t.Errorf("expected stale hit, got %+v", status)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&hits) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if hits := atomic.LoadInt64(&hits); hits != 2 {
+		t.Errorf("expected one background refresh, got %d requests", hits)
+	}
+}
+
+func TestCacheShortensTTLOfRetryableErrors(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	opts := Options{Registry: NewRegistry(testPlatform("slow", server.URL+"/slow")), Timeout: 50 * time.Millisecond}
+	cache := NewCache(nil, time.Hour)
+	cache.ErrorTTL = time.Minute
+
+	_, status, _ := cache.Collect(context.Background(), server.URL+"/", opts)
+	if status.TTL != time.Minute {
+		t.Errorf("expected timed out result to be kept for a minute, got %v", status.TTL)
+	}
+
+	cache = NewCache(nil, time.Hour)
+	cache.ErrorTTL = -1
+	cache.Collect(context.Background(), server.URL+"/", opts)
+
+	if _, status, _ = cache.Collect(context.Background(), server.URL+"/", opts); status.Hit {
+		t.Error("expected timed out result not to be cached")
+	}
+
+	opts.Registry = NewRegistry(testPlatform("missing", server.URL+"/missing"))
+	if _, status, _ = cache.Collect(context.Background(), server.URL+"/", opts); status.TTL != time.Hour {
+		t.Errorf("expected result with permanent error to be kept for TTL, got %v", status.TTL)
+	}
+}
+
+func TestCacheBoundsRefresh(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	// Platform waits for rate limit, which isn't bounded by timeout of
+	// requests.
+	limiter := NewRateLimiter(map[string]RateLimit{"ok": {RPS: 0.001, MaxQueue: -1}})
+	limiter.Wait(context.Background(), "ok")
+
+	opts := Options{
+		Registry:    NewRegistry(testPlatform("ok", server.URL+"/count")),
+		Timeout:     20 * time.Millisecond,
+		Deadline:    -1,
+		RateLimiter: limiter,
+	}
+
+	cache := NewCache(nil, time.Hour)
+	cache.refresh("key", server.URL+"/", opts, time.Hour)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		cache.mu.Lock()
+		refreshing := cache.refreshing["key"]
+		cache.mu.Unlock()
+
+		if !refreshing {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Error("expected refresh to finish within its timeout")
+}
+
+func TestDiskCacheKeepsEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socol-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	result := newResult("https://golang.org/")
+	result.Platforms["facebook"] = &PlatformResult{Name: "facebook", Count: 42, FetchedIn: time.Second}
+	result.Origin = &Origin{Title: "Go", URLs: []string{"https://golang.org/"}}
+	result.Meta.Total = 42
+
+	backend, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend.Set("key", &CacheEntry{URL: result.URL, Result: result, StoredAt: time.Now(), TTL: time.Minute})
+
+	entry, ok := backend.Get("key")
+	if !ok {
+		t.Fatal("expected entry to be stored")
+	}
+
+	if entry.Result.URL != result.URL || entry.Result.Meta.Total != 42 || entry.TTL != time.Minute {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if p := entry.Result.Platforms["facebook"]; p == nil || p.Count != 42 || p.FetchedIn != time.Second {
+		t.Errorf("unexpected platform result %+v", p)
+	}
+
+	if entry.Result.Origin == nil || entry.Result.Origin.Title != "Go" {
+		t.Errorf("unexpected origin %+v", entry.Result.Origin)
+	}
+}
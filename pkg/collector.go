@@ -1,18 +1,13 @@
 package collector
 
 import (
-	"crypto/tls"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"math/rand"
 	"net/http"
-	"net/url"
-	"os"
-	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,25 +25,13 @@ type Platform struct {
 	format    string
 }
 
+// Formats maps names of supported formats to their media types.
 var Formats = map[string]string{
 	"xml":   "text/xml",
 	"jsonp": "application/javascript",
 	"json":  "application/json",
 }
 
-var platforms []Platform = []Platform{
-	Facebook(),
-	Pinterest(),
-	Linkedin(),
-	GooglePlus(),
-	Reddit(),
-	Bufferapp(),
-	Stumbleupon(),
-	Pocket(),
-	Tumblr(),
-	Origin(),
-}
-
 func parseJSONP(body []byte) (string, error) {
 	jsBody := string(body)
 	iStart := strings.Index(jsBody, "(")
@@ -61,150 +44,310 @@ func parseJSONP(body []byte) (string, error) {
 	return jsBody[iStart+1 : iEnd], nil
 }
 
-func buildClientAsync() (*http.Client, error) {
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-
-	if proxy != "" {
-		proxyThing, error := url.Parse(proxy)
-		if error != nil {
-			return &http.Client{}, error
+func buildClientAsync(opts Options) (*http.Client, error) {
+	if opts.HTTPClient != nil {
+		client := *opts.HTTPClient
+		if opts.Timeout > 0 {
+			client.Timeout = opts.Timeout
 		}
 
-		transport.Proxy = http.ProxyURL(proxyThing)
+		return &client, nil
+	}
+
+	transport, error := opts.transports().RoundTripper(opts)
+	if error != nil {
+		return &http.Client{}, error
 	}
 
 	return &http.Client{
-		Timeout:   globalTimeout,
+		Timeout:   opts.timeout(),
 		Transport: transport,
 	}, nil
 }
 
-func (platform Platform) doRequest(lookupURL string, stats chan<- Stat, errorsChannel chan *error) {
-	start := time.Now()
-	fullURL := fmt.Sprintf(platform.statsURL, lookupURL)
-	logger.Println(platform.name, "Requesting", fullURL)
+// Name returns name of platform.
+func (platform Platform) Name() string {
+	return platform.name
+}
 
-	client, err := buildClientAsync()
-	if err != nil {
-		errorsChannel <- &err
-		return
+// BuildRequest builds request to platform stats endpoint.
+func (platform Platform) BuildRequest(lookupURL string) (*http.Request, error) {
+	request, error := http.NewRequest("GET", fmt.Sprintf(platform.statsURL, lookupURL), nil)
+	if error != nil {
+		return nil, error
 	}
 
-	request, error := http.NewRequest("GET", fullURL, nil)
-	request.Header.Set("User-Agent", strings.Join([]string{"Mozilla/5.0 (socol) ", strconv.Itoa(rand.Intn(1000))}, " "))
 	if platform.format != "" {
-		logger.Println("Setting content type to", platform.format)
 		request.Header.Set("Content-Type", platform.format)
 	}
 
+	return request, nil
+}
+
+// Parse parses platform stats from response.
+func (platform Platform) Parse(response *http.Response) (*PlatformResult, error) {
+	stat, error := platform.parseWith(response)
 	if error != nil {
-		errorsChannel <- &error
-		return
+		return nil, error
+	}
+
+	return stat.toResult(platform.name), nil
+}
+
+// doRequest fetches stats from provider once rate limit allows, retrying
+// failed attempts as retry policy of provider allows. It always returns
+// exactly one result, with Error set when stats could not be collected.
+func doRequest(ctx context.Context, provider Provider, lookupURL string, opts Options) *PlatformResult {
+	start := time.Now()
+	name := provider.Name()
+	policy := opts.retryPolicy(name)
+	queuedIn := time.Duration(0)
+	for attempt := 1; ; attempt++ {
+		queued, err := opts.rateLimiter().Wait(ctx, name)
+		queuedIn += queued
+
+		var result *PlatformResult
+		if err != nil {
+			LoggerFrom(ctx).Warn("Collecting stats failed", "platform", name, "url", lookupURL, "error", err)
+			result = &PlatformResult{Name: name, Error: err, Attempts: attempt - 1, CompletedIn: time.Now().Sub(start)}
+		} else {
+			result = doAttempt(ctx, provider, lookupURL, opts, start, attempt)
+			result.Attempts = attempt
+		}
+		result.QueuedIn = queuedIn
+
+		wait, retry := policy.retries(attempt, result.Error)
+		if !retry || !waitRetry(ctx, wait) {
+			return result
+		}
+	}
+}
+
+// doAttempt makes a single request to provider. Timings are measured from
+// start of the first attempt.
+func doAttempt(ctx context.Context, provider Provider, lookupURL string, opts Options, start time.Time, attempt int) (result *PlatformResult) {
+	name := provider.Name()
+	log := LoggerFrom(ctx).With("platform", name, "url", lookupURL, "attempt", attempt)
+	status := 0
+	failed := func(err *PlatformError) *PlatformResult {
+		completedIn := time.Now().Sub(start)
+		log.Warn("Collecting stats failed", "status", status, "duration", completedIn.Seconds(), "error", err)
+		return &PlatformResult{Name: name, Error: err, CompletedIn: completedIn}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = failed(&PlatformError{Platform: name, Kind: ErrorParse, StatusCode: status,
+				Err: fmt.Errorf("Parsing %s stats panicked: %v", name, r)})
+		}
+	}()
+
+	client, err := buildClientAsync(opts.forPlatform(name))
+	if err != nil {
+		return failed(&PlatformError{Platform: name, Kind: ErrorNetwork, Err: err})
+	}
+
+	request, error := provider.BuildRequest(lookupURL)
+	if error != nil {
+		return failed(&PlatformError{Platform: name, Kind: ErrorParse, Err: error})
+	}
+
+	request = request.WithContext(ctx)
+
+	fullURL := request.URL.String()
+	log.Debug("Requesting stats", "request_url", fullURL)
+	if request.Header.Get("User-Agent") == "" {
+		request.Header.Set("User-Agent", opts.userAgent())
 	}
 
 	response, error := client.Do(request)
 	if error != nil {
-		errorsChannel <- &error
-		return
+		return failed(requestError(name, error, ErrorNetwork, opts))
 	}
+	defer response.Body.Close()
 
+	status = response.StatusCode
 	if response.StatusCode != http.StatusOK {
-		error := errors.New("Got non OK HTTP status at " + response.Status + "-" + fullURL)
-		errorsChannel <- &error
+		statusErr := statusError(name, status, errors.New("Got non OK HTTP status at "+response.Status+"-"+fullURL))
+		statusErr.retryAfter = parseRetryAfter(response)
+		return failed(statusErr)
 	}
 
-	fetchedIn := time.Now().Sub(start).Seconds()
+	fetchedIn := time.Now().Sub(start)
 
-	stat, error := platform.parseWith(response)
+	result, error = provider.Parse(response)
 	if error != nil {
-		errorsChannel <- &error
-		return
+		platformError := requestError(name, error, ErrorParse, opts)
+		platformError.StatusCode = status
+		return failed(platformError)
 	}
 
-	if stat.data == nil {
-		stat.data = map[string]interface{}{}
+	if result == nil {
+		result = &PlatformResult{}
 	}
 
-	stat.data["fetched_in"] = fetchedIn
-	stat.data["completed_in"] = time.Now().Sub(start).Seconds()
+	if result.Fields == nil {
+		result.Fields = map[string]interface{}{}
+	}
 
-	logger.Println(platform.name, "Completed in", stat.data["completed_in"], "s")
+	result.Name = name
+	result.FetchedIn = fetchedIn
+	result.CompletedIn = time.Now().Sub(start)
 
-	stat.name = platform.name
-	stats <- stat
-	return
+	log.Debug("Collected stats", "status", status, "duration", result.CompletedIn.Seconds(), "count", result.Count)
+	return result
+}
+
+// collectPlatforms queries providers concurrently. Returned channel receives
+// exactly one result per provider as soon as it completes and is closed once
+// all providers are done. It is buffered, so no goroutine is left behind
+// when results are not read.
+func collectPlatforms(ctx context.Context, providers []Provider, variants []string, opts Options) <-chan *PlatformResult {
+	results := make(chan *PlatformResult, len(providers))
+	var wg sync.WaitGroup
+
+	for _, provider := range providers {
+		wg.Add(1)
+		go func(provider Provider) {
+			defer wg.Done()
+			if len(variants) == 1 {
+				results <- doRequest(ctx, provider, variants[0], opts)
+			} else {
+				results <- doVariantsRequest(ctx, provider, variants, opts)
+			}
+		}(provider)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
 }
 
-func resolveAndOpenGraph(url string) (stat Stat, urls []string, err error) {
+func (stat Stat) toResult(name string) *PlatformResult {
+	result := &PlatformResult{
+		Name:   name,
+		Count:  toCount(stat.data["count"]),
+		Fields: map[string]interface{}{},
+	}
+
+	for k, v := range stat.data {
+		if k != "count" {
+			result.Fields[k] = v
+		}
+	}
+
+	return result
+}
+
+func resolveAndOpenGraph(ctx context.Context, url string, opts Options) (origin *Origin, urls []string, err error) {
 	start := time.Now()
-	stat.name = "origin"
 	err = nil
 	urls = append(urls, url)
+	redirects := []*Redirect{}
 
-	client, e := buildClientAsync()
-	if e != nil {
+	// partial keeps redirect chain when page itself couldn't be read.
+	partial := func(e error, status int) {
+		origin = &Origin{URLs: urls, Redirects: redirects, Status: status, CompletedIn: time.Now().Sub(start)}
 		err = e
+	}
+
+	client, e := buildClientAsync(opts.forPlatform("origin"))
+	if e != nil {
+		err = &PlatformError{Platform: "origin", Kind: ErrorNetwork, Err: e}
 		return
 	}
 
-	logger.Println(stat.name, "Requesting", url)
+	log := LoggerFrom(ctx).With("platform", "origin", "url", url)
+	log.Debug("Requesting origin")
 
+	hopStart := start
+	visited := map[string]bool{url: true}
+	var redirectError *PlatformError
 	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		urls = append(urls, req.URL.String())
-		logger.Println(stat.name, "Got", req.URL.String())
+		redirects = append(redirects, newRedirect(via[len(via)-1], req, time.Now().Sub(hopStart)))
+		hopStart = time.Now()
+
+		next := req.URL.String()
+		if visited[next] {
+			redirectError = statusError("origin", req.Response.StatusCode, errors.New("Redirect loop detected at "+next+"-"+url))
+			redirectError.Retryable = false
+			return http.ErrUseLastResponse
+		}
+
+		if len(via) > opts.maxRedirects() {
+			redirectError = statusError("origin", req.Response.StatusCode,
+				errors.New("Stopped after "+strconv.Itoa(opts.maxRedirects())+" redirects-"+url))
+			redirectError.Retryable = false
+			return http.ErrUseLastResponse
+		}
+
+		visited[next] = true
+		urls = append(urls, next)
+		log.Debug("Following redirect", "status", req.Response.StatusCode, "location", next)
 		return nil
 	}
 
 	request, e := http.NewRequest("GET", url, nil)
-	request.Header.Set("User-Agent", "Googlebot-News")
 	if e != nil {
-		err = e
+		err = &PlatformError{Platform: "origin", Kind: ErrorParse, Err: e}
 		return
 	}
 
+	request = request.WithContext(ctx)
+	if opts.UserAgent != "" {
+		request.Header.Set("User-Agent", opts.UserAgent)
+	} else {
+		request.Header.Set("User-Agent", "Googlebot-News")
+	}
+
 	response, e := client.Do(request)
 	if e != nil {
-		err = e
+		err = requestError("origin", e, ErrorNetwork, opts)
+		if len(redirects) > 0 {
+			partial(err, 0)
+		}
 		return
 	}
+	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK {
-		err = errors.New("Got non OK HTTP status at " + response.Status + "-" + url)
+	if redirectError != nil {
+		partial(redirectError, response.StatusCode)
 		return
 	}
 
-	fetchedIn := time.Now().Sub(start).Seconds()
-	stat, e = platforms[len(platforms)-1].parseWith(response)
-	if e != nil {
-		err = e
+	if response.StatusCode != http.StatusOK {
+		partial(statusError("origin", response.StatusCode, errors.New("Got non OK HTTP status at "+response.Status+"-"+url)), response.StatusCode)
 		return
 	}
 
-	stat.name = "origin"
-	stat.data["fetched_in"] = fetchedIn
-	stat.data["completed_in"] = time.Now().Sub(start).Seconds()
-	logger.Println(stat.name, "Completed in", stat.data["completed_in"], "s")
-
-	if stat.data == nil {
-		stat.data = map[string]interface{}{}
-	} else {
-		stat.data["urls"] = urls
+	fetchedIn := time.Now().Sub(start)
+	origin, e = parseOrigin(response)
+	if e != nil {
+		parseError := requestError("origin", e, ErrorParse, opts)
+		parseError.StatusCode = response.StatusCode
+		partial(parseError, response.StatusCode)
+		return
 	}
 
+	origin.URLs = urls
+	origin.Redirects = redirects
+	origin.Status = response.StatusCode
+	origin.FetchedIn = fetchedIn
+	origin.CompletedIn = time.Now().Sub(start)
+	log.Debug("Collected origin", "status", origin.Status, "duration", origin.CompletedIn.Seconds())
 	return
 }
 
-func canRunPlatform(platform *Platform, selectedPlatforms *[]string) (canRun bool) {
+func canRunPlatform(provider Provider, selectedPlatforms *[]string) (canRun bool) {
 	canRun = false
-	if platform.name == "origin" {
+	if provider.Name() == "origin" {
 		return false
 	}
 
-	if platform.enabled == false {
+	if platform, ok := provider.(Platform); ok && platform.enabled == false {
 		return false
 	}
 
@@ -213,7 +356,7 @@ func canRunPlatform(platform *Platform, selectedPlatforms *[]string) (canRun boo
 	}
 
 	for _, name := range *selectedPlatforms {
-		if platform.name == name && platform.enabled == true {
+		if provider.Name() == name {
 			canRun = true
 			return true
 		}
@@ -222,96 +365,103 @@ func canRunPlatform(platform *Platform, selectedPlatforms *[]string) (canRun boo
 	return
 }
 
-func aggregateAndCombine(results map[string]interface{}, errors []error) map[string]interface{} {
-	total := 0
-	for _, p := range results {
-		c := p.(map[string]interface{})["count"]
-		if reflect.ValueOf(c).Kind() == reflect.Int {
-			total += c.(int)
-		} else if reflect.ValueOf(c).Kind() == reflect.Float64 {
-			n, _ := strconv.Atoi(strconv.FormatFloat(c.(float64), 'f', 0, 64))
-			total += n
-		} else if reflect.ValueOf(c).Kind() == reflect.Float32 {
-			n, _ := strconv.Atoi(strconv.FormatFloat(c.(float64), 'f', 0, 32))
-			total += n
-		} else {
-			// logger.Fatal("Can't cast...")
-		}
+func aggregateAndCombine(result *Result, errors []error) *Result {
+	var total int64
+	for _, platform := range result.Platforms {
+		total += platform.Count
 	}
 
-	results["meta"] = map[string]interface{}{"total": total}
+	result.Meta.Total = total
+	result.Errors = append(result.Errors, errors...)
+	return result
+}
 
-	errorsStrings := []string{}
-	for _, error := range errors {
-		errorsStrings = append(errorsStrings, error.Error())
-	}
+var globalTimeout = time.Duration(4 * time.Second)
 
-	results["errors"] = errorsStrings
-	return results
+// New collects stats for lookupURL from selected platforms, or from all
+// enabled platforms when none are selected. Calls share DefaultRateLimiter.
+func New(lookupURL string, selectedPlatforms []string, privateProxy string) *Result {
+	result, _ := Collect(context.Background(), lookupURL, Options{
+		Platforms: selectedPlatforms,
+		Proxy:     privateProxy,
+	})
+
+	return result
 }
 
-var proxy = ""
-var logger *log.Logger
-var errorsLogger *log.Logger
-var globalTimeout = time.Duration(4 * time.Second)
+// Collect collects stats for lookupURL as configured by opts. Outbound
+// requests are cancelled when ctx is done or Deadline of opts passes. When
+// ctx is done partial result is returned together with ctx error.
+func Collect(ctx context.Context, lookupURL string, opts Options) (*Result, error) {
+	return collect(ctx, lookupURL, opts, func(Event) {})
+}
 
-func init() {
-	logLevel := os.Getenv("LOG_LEVEL")
-	if logLevel == "" {
-		logger = log.New(ioutil.Discard, "socol ", log.Ldate|log.Ltime|log.Lshortfile)
-		errorsLogger = log.New(ioutil.Discard, "socol ", log.Ldate|log.Ltime|log.Lshortfile)
-	} else {
-		logger = log.New(os.Stdout, "socol ", log.Ldate|log.Ltime|log.Lshortfile)
-		errorsLogger = log.New(os.Stderr, "socol ", log.Ldate|log.Ltime|log.Lshortfile)
+// collect collects stats and reports origin and platform results to emit
+// as they complete.
+func collect(ctx context.Context, lookupURL string, opts Options, emit func(Event)) (*Result, error) {
+	parent := ctx
+	if deadline := opts.deadline(); deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
 	}
-}
 
-func New(lookupURL string, selectedPlatforms []string, privateProxy string) map[string]interface{} {
-	proxy = privateProxy
+	selectedPlatforms := append([]string{}, opts.Platforms...)
 
-	if selectedPlatforms == nil ||
-		(len(selectedPlatforms) == 1 && selectedPlatforms[0] == "") {
+	if len(selectedPlatforms) == 1 && selectedPlatforms[0] == "" {
 		selectedPlatforms = []string{}
 	}
 
 	selectedPlatforms = append(selectedPlatforms, "origin")
-	errors, stats, taskCount := make(chan *error), make(chan Stat), 0
-	aggregated := map[string]interface{}{}
+	aggregated := newResult(lookupURL)
 	errorsCollection := []error{}
 
-	rStat, urls, rError := resolveAndOpenGraph(lookupURL)
+	origin, urls, rError := resolveAndOpenGraph(ctx, lookupURL, opts)
 	if rError != nil {
-		errorsLogger.Println(rError)
-	} else {
-		aggregated[rStat.name] = rStat.data
+		LoggerFrom(ctx).Warn("Collecting origin failed", "platform", "origin", "url", lookupURL, "error", rError)
+		errorsCollection = append(errorsCollection, rError)
+	}
+
+	if origin != nil {
+		aggregated.Origin = origin
+		emit(Event{Origin: origin})
 	}
 
 	if len(urls) > 1 {
-		logger.Println("Digging for", urls)
+		LoggerFrom(ctx).Debug("Resolved redirects", "url", lookupURL, "urls", urls)
 	}
 
 	lookupURL = urls[len(urls)-1]
+	if opts.Canonicalize {
+		lookupURL = canonicalURL(lookupURL, origin, opts)
+	} else {
+		lookupURL = Normalize(lookupURL, opts.stripParams())
+	}
 
-	for _, platform := range platforms {
-		if canRunPlatform(&platform, &selectedPlatforms) {
-			go platform.doRequest(lookupURL, stats, errors)
-			taskCount++
+	variants := []string{lookupURL}
+	if opts.QueryVariants {
+		variants = Variants(lookupURL)
+	}
+
+	aggregated.Meta.LookupURL = lookupURL
+	if len(variants) > 1 {
+		aggregated.Meta.Variants = variants
+	}
+
+	providers := []Provider{}
+	for _, provider := range opts.registry().Providers() {
+		if canRunPlatform(provider, &selectedPlatforms) {
+			providers = append(providers, provider)
 		}
 	}
 
-	for {
-		select {
-		case stat := <-stats:
-			aggregated[stat.name] = stat.data
-			taskCount--
-		case error := <-errors:
-			errorsLogger.Println(*error)
-			errorsCollection = append(errorsCollection, *error)
-			taskCount--
-		default:
-			if taskCount <= 0 {
-				return aggregateAndCombine(aggregated, errorsCollection)
-			}
+	for result := range collectPlatforms(ctx, providers, variants, opts) {
+		aggregated.Platforms[result.Name] = result
+		if result.Error != nil {
+			errorsCollection = append(errorsCollection, result.Error)
 		}
+		emit(Event{Platform: result})
 	}
+
+	return aggregateAndCombine(aggregated, errorsCollection), parent.Err()
 }
@@ -0,0 +1,503 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer() *httptest.Server {
+	return httptest.NewServer(testHandler())
+}
+
+func testHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta property="og:title" content="Test page" /></head></html>`))
+	})
+
+	mux.HandleFunc("/count", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"count": 3}`))
+	})
+
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"count":`))
+	})
+
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	})
+
+	return mux
+}
+
+func testPlatform(name string, statsURL string) Platform {
+	return Platform{
+		enabled:  true,
+		name:     name,
+		statsURL: statsURL + "?url=%s",
+		parseWith: func(r *http.Response) (Stat, error) {
+			body, error := ioutil.ReadAll(r.Body)
+			if error != nil {
+				return Stat{}, error
+			}
+
+			var jsonBlob map[string]interface{}
+			if err := json.Unmarshal(body, &jsonBlob); err != nil {
+				return Stat{}, err
+			}
+
+			return Stat{data: map[string]interface{}{"count": jsonBlob["count"]}}, nil
+		},
+	}
+}
+
+func TestCollectReturnsOneResultPerPlatform(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	panicking := testPlatform("panicking", server.URL+"/count")
+	panicking.parseWith = func(r *http.Response) (Stat, error) {
+		panic("unexpected payload")
+	}
+
+	registry := NewRegistry(
+		testPlatform("ok", server.URL+"/count"),
+		testPlatform("other", server.URL+"/count"),
+		testPlatform("missing", server.URL+"/missing"),
+		testPlatform("broken", server.URL+"/broken"),
+		panicking,
+	)
+
+	result, err := Collect(context.Background(), server.URL+"/", Options{Registry: registry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Platforms) != 5 {
+		t.Fatalf("expected 5 platform results, got %d", len(result.Platforms))
+	}
+
+	for _, name := range []string{"missing", "broken", "panicking"} {
+		if result.Platforms[name].Error == nil {
+			t.Errorf("expected %s to fail", name)
+		}
+	}
+
+	if len(result.Errors) != 3 {
+		t.Errorf("expected 3 errors, got %v", result.Errors)
+	}
+
+	if result.Meta.Total != 6 {
+		t.Errorf("expected total of 6, got %d", result.Meta.Total)
+	}
+
+	if result.Origin == nil || result.Origin.Title != "Test page" {
+		t.Errorf("expected origin title, got %+v", result.Origin)
+	}
+}
+
+func TestCollectStopsWhenContextIsDone(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	registry := NewRegistry(testPlatform("slow", server.URL+"/slow"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := Collect(ctx, server.URL+"/", Options{Registry: registry})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected collection to stop early, took %v", elapsed)
+	}
+
+	if result.Platforms["slow"].Error == nil {
+		t.Error("expected slow platform to fail")
+	}
+}
+
+func TestCollectDoesNotLeakGoroutines(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	registry := NewRegistry()
+	for i := 0; i < 30; i++ {
+		paths := []string{"/count", "/missing", "/broken"}
+		registry.Register(testPlatform(fmt.Sprintf("platform_%d", i), server.URL+paths[i%len(paths)]))
+	}
+
+	transports := NewTransports()
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		lookupURL := fmt.Sprintf("%s/?i=%d", server.URL, i)
+		result, _ := Collect(context.Background(), lookupURL, Options{Registry: registry, Transports: transports})
+		if len(result.Platforms) != 30 {
+			t.Fatalf("expected 30 platform results, got %d", len(result.Platforms))
+		}
+
+		if len(result.Errors) != 20 {
+			t.Fatalf("expected 20 errors, got %d", len(result.Errors))
+		}
+	}
+
+	transports.CloseIdleConnections()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected at most %d goroutines, got %d", before, after)
+	}
+}
+
+func TestCollectKeepsProxiesPerCall(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	var mu sync.Mutex
+	seen := map[string][]string{}
+	newProxy := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			seen[name] = append(seen[name], r.URL.String())
+			mu.Unlock()
+			server.Config.Handler.ServeHTTP(w, r)
+		}))
+	}
+
+	proxyA, proxyB := newProxy("a"), newProxy("b")
+	defer proxyA.Close()
+	defer proxyB.Close()
+
+	registry := NewRegistry(testPlatform("ok", server.URL+"/count"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for name, proxy := range map[string]string{"a": proxyA.URL, "b": proxyB.URL} {
+			wg.Add(1)
+			go func(name string, proxy string) {
+				defer wg.Done()
+				lookupURL := fmt.Sprintf("%s/?via=%s", server.URL, name)
+				Collect(context.Background(), lookupURL, Options{Registry: registry, Proxy: proxy})
+			}(name, proxy)
+		}
+	}
+	wg.Wait()
+
+	if len(seen) != 2 {
+		t.Fatalf("expected requests through both proxies, got %v", seen)
+	}
+
+	for name, urls := range seen {
+		if len(urls) != 40 {
+			t.Errorf("expected 40 requests through proxy %s, got %d", name, len(urls))
+		}
+
+		for _, u := range urls {
+			if !strings.Contains(u, "via%3D"+name) && !strings.Contains(u, "via="+name) {
+				t.Errorf("proxy %s got request for %s", name, u)
+			}
+		}
+	}
+}
+
+func TestCollectReusesConnections(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	registry := NewRegistry(testPlatform("ok", server.URL+"/count"))
+	transports := NewTransports()
+	defer transports.CloseIdleConnections()
+
+	for i := 0; i < 5; i++ {
+		Collect(context.Background(), server.URL+"/", Options{Registry: registry, Transports: transports})
+	}
+
+	stats := transports.Stats()
+	if stats.Transports != 1 || stats.Requests != 10 {
+		t.Errorf("expected 10 requests over 1 transport, got %+v", stats)
+	}
+
+	if stats.ReusedConnections == 0 {
+		t.Errorf("expected connections to be reused, got %+v", stats)
+	}
+}
+
+func TestTransportsDropLeastRecentlyUsed(t *testing.T) {
+	transports := NewTransports()
+	transports.MaxTransports = 2
+	defer transports.CloseIdleConnections()
+
+	for _, proxy := range []string{"http://127.0.0.1:1", "http://127.0.0.1:2", "http://127.0.0.1:1", "http://127.0.0.1:3"} {
+		if _, err := transports.RoundTripper(Options{Proxy: proxy}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := transports.Stats(); stats.Transports != 2 {
+		t.Errorf("expected pool to keep 2 transports, got %+v", stats)
+	}
+
+	if _, ok := transports.transports[transportKey{proxy: "http://127.0.0.1:2"}]; ok {
+		t.Error("expected least recently used transport to be dropped")
+	}
+}
+
+func TestCollectVerifiesCertificates(t *testing.T) {
+	server := httptest.NewTLSServer(testHandler())
+	defer server.Close()
+
+	registry := NewRegistry(testPlatform("ok", server.URL+"/count"))
+	transports := NewTransports()
+	defer transports.CloseIdleConnections()
+
+	result, _ := Collect(context.Background(), server.URL+"/", Options{Registry: registry, Transports: transports})
+	if result.Origin != nil || result.Platforms["ok"].Error == nil {
+		t.Error("expected unknown certificate to be rejected")
+	}
+
+	result, _ = Collect(context.Background(), server.URL+"/", Options{
+		Registry:          registry,
+		Transports:        transports,
+		InsecurePlatforms: []string{"ok"},
+	})
+	if result.Origin != nil || result.Platforms["ok"].Error != nil {
+		t.Errorf("expected only platform to skip verification, got %v", result.Errors)
+	}
+
+	caFile, err := ioutil.TempFile("", "socol-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile.Close()
+
+	result, _ = Collect(context.Background(), server.URL+"/", Options{
+		Registry:   registry,
+		Transports: transports,
+		CAFile:     caFile.Name(),
+	})
+	if len(result.Errors) != 0 {
+		t.Errorf("expected certificate from CA file to be trusted, got %v", result.Errors)
+	}
+}
+
+func TestStreamEmitsPlatformsBeforeResult(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	registry := NewRegistry(
+		testPlatform("ok", server.URL+"/count"),
+		testPlatform("missing", server.URL+"/missing"),
+	)
+
+	platforms := map[string]bool{}
+	var last Event
+	for event := range Stream(context.Background(), server.URL+"/", Options{Registry: registry}) {
+		if last.Result != nil {
+			t.Fatal("expected result to be the last event")
+		}
+
+		if event.Platform != nil {
+			platforms[event.Platform.Name] = true
+		}
+		last = event
+	}
+
+	if len(platforms) != 2 {
+		t.Errorf("expected events for 2 platforms, got %v", platforms)
+	}
+
+	if last.Result == nil || last.Result.Meta.Total != 3 {
+		t.Errorf("expected aggregated result last, got %+v", last)
+	}
+}
+
+func TestCollectLogsWithRequestID(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	var output bytes.Buffer
+	defer func(logger *slog.Logger) { Logger = logger }(Logger)
+	Logger = NewLogger(&output, "debug", "json")
+
+	registry := NewRegistry(testPlatform("ok", server.URL+"/count"), testPlatform("missing", server.URL+"/missing"))
+	Collect(WithRequestID(context.Background(), "abc"), server.URL+"/", Options{Registry: registry})
+
+	records := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON log line, got %q", line)
+		}
+
+		if record["request_id"] != "abc" {
+			t.Errorf("expected request ID in %q", line)
+		}
+		records[fmt.Sprint(record["platform"], " ", record["msg"])] = record
+	}
+
+	if record := records["ok Collected stats"]; record == nil || record["status"] != float64(200) || record["url"] != server.URL+"/" {
+		t.Errorf("expected completed platform to be logged, got %v", records)
+	}
+
+	if record := records["missing Collecting stats failed"]; record == nil || record["level"] != "WARN" || record["status"] != float64(404) {
+		t.Errorf("expected failed platform to be logged, got %v", records)
+	}
+}
+
+func TestNewLoggerLevels(t *testing.T) {
+	var output bytes.Buffer
+	logger := NewLogger(&output, "warn", "")
+	logger.Info("hidden")
+	logger.Warn("shown", "platform", "facebook")
+
+	if line := output.String(); strings.Contains(line, "hidden") || !strings.Contains(line, "level=WARN msg=shown platform=facebook") {
+		t.Errorf("unexpected logfmt output %q", line)
+	}
+}
+
+func TestCollectReportsPlatformErrors(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	closed := httptest.NewServer(testHandler())
+	closed.Close()
+
+	registry := NewRegistry(
+		testPlatform("missing", server.URL+"/missing"),
+		testPlatform("broken", server.URL+"/broken"),
+		testPlatform("slow", server.URL+"/slow"),
+		testPlatform("unreachable", closed.URL+"/count"),
+	)
+	result, _ := Collect(context.Background(), server.URL+"/", Options{Registry: registry, Timeout: 200 * time.Millisecond})
+
+	expected := map[string]PlatformError{
+		"missing":     {Kind: ErrorHTTPStatus, StatusCode: 404},
+		"broken":      {Kind: ErrorParse, StatusCode: 200},
+		"slow":        {Kind: ErrorTimeout, Retryable: true},
+		"unreachable": {Kind: ErrorNetwork, Retryable: true},
+	}
+
+	for name, want := range expected {
+		var platformError *PlatformError
+		if !errors.As(result.Platforms[name].Error, &platformError) {
+			t.Errorf("expected %s to fail with PlatformError, got %v", name, result.Platforms[name].Error)
+			continue
+		}
+
+		if platformError.Platform != name || platformError.Kind != want.Kind ||
+			platformError.StatusCode != want.StatusCode || platformError.Retryable != want.Retryable {
+			t.Errorf("unexpected error of %s: %+v", name, platformError)
+		}
+	}
+
+	body, _ := json.Marshal(result)
+	decoded := &Result{}
+	if err := json.Unmarshal(body, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Errors) != 4 {
+		t.Fatalf("expected 4 errors, got %v", decoded.Errors)
+	}
+
+	var platformError *PlatformError
+	if !errors.As(decoded.Platforms["missing"].Error, &platformError) || platformError.StatusCode != 404 {
+		t.Errorf("expected platform errors to survive JSON, got %s", body)
+	}
+}
+
+func TestPlatformErrorWithoutErr(t *testing.T) {
+	err := &PlatformError{Platform: "reddit", Kind: ErrorHTTPStatus, StatusCode: 503}
+	if message := err.Error(); message != "Collecting stats of reddit failed with http_status status 503." {
+		t.Errorf("unexpected message %q", message)
+	}
+
+	if message := (&PlatformError{}).Error(); message == "" {
+		t.Error("expected zero value to have message")
+	}
+}
+
+func TestResultKeepsStatsShape(t *testing.T) {
+	result := newResult("http://example.com/")
+	result.Platforms["facebook"] = &PlatformResult{
+		Name:        "facebook",
+		Count:       12,
+		FetchedIn:   500 * time.Millisecond,
+		CompletedIn: 750 * time.Millisecond,
+		Fields:      map[string]interface{}{"shares": 12},
+	}
+	result.Platforms["reddit"] = &PlatformResult{
+		Name:  "reddit",
+		Error: &PlatformError{Platform: "reddit", Kind: ErrorHTTPStatus, StatusCode: 503},
+	}
+	result.Origin = &Origin{
+		Title:       "Example",
+		URLs:        []string{"http://example.com/"},
+		FetchedIn:   250 * time.Millisecond,
+		CompletedIn: 500 * time.Millisecond,
+	}
+	result.Meta.Total = 12
+	result.Errors = append(result.Errors, result.Platforms["reddit"].Error)
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := `{"errors":["Collecting stats of reddit failed with http_status status 503."],` +
+		`"facebook":{"completed_in":0.75,"count":12,"fetched_in":0.5,"shares":12},` +
+		`"meta":{"total":12},` +
+		`"origin":{"Title":"Example","completed_in":0.5,"fetched_in":0.25,"redirects":null,"urls":["http://example.com/"]},` +
+		`"platform_errors":[{"platform":"reddit","kind":"http_status","status":503,"retryable":false,` +
+		`"message":"Collecting stats of reddit failed with http_status status 503."}]}`
+	if string(body) != golden {
+		t.Errorf("expected /stats shape\n%s\ngot\n%s", golden, body)
+	}
+}
+
+func TestCollectReportsCancellation(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	registry := NewRegistry(testPlatform("slow", server.URL+"/slow"))
+	result, _ := Collect(ctx, server.URL+"/", Options{Registry: registry})
+
+	var platformError *PlatformError
+	if !errors.As(result.Platforms["slow"].Error, &platformError) || platformError.Kind != ErrorCanceled || platformError.Retryable {
+		t.Errorf("expected cancellation not to be retryable, got %+v", platformError)
+	}
+}
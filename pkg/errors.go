@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of platform errors.
+const (
+	// ErrorTimeout is set when platform didn't respond in time.
+	ErrorTimeout = "timeout"
+	// ErrorHTTPStatus is set when platform responded with status other
+	// than 200 OK.
+	ErrorHTTPStatus = "http_status"
+	// ErrorParse is set when stats couldn't be read from response.
+	ErrorParse = "parse"
+	// ErrorNetwork is set when platform couldn't be reached.
+	ErrorNetwork = "network"
+	// ErrorProxy is set when proxy couldn't be reached or refused request.
+	ErrorProxy = "proxy"
+	// ErrorRateLimited is set when request wasn't made because queue of
+	// platform's rate limit was full or wait would outlast deadline.
+	ErrorRateLimited = "rate_limited"
+	// ErrorCanceled is set when request was cancelled by caller, e.g. when
+	// client disconnected.
+	ErrorCanceled = "canceled"
+)
+
+// PlatformError describes why stats of a platform, or of "origin", could
+// not be collected. Errors of Result and PlatformResult are of this type, so
+// callers can inspect them with errors.As.
+type PlatformError struct {
+	Platform string
+	// Kind is one of ErrorTimeout, ErrorHTTPStatus, ErrorParse,
+	// ErrorNetwork, ErrorProxy, ErrorRateLimited and ErrorCanceled.
+	Kind string
+	// StatusCode is HTTP status of response, 0 when there was none.
+	StatusCode int
+	// Retryable is true when the same request may succeed later.
+	Retryable bool
+	Err       error
+
+	retryAfter time.Duration
+}
+
+// Error returns message of the underlying error, or describes kind and
+// status of error without one.
+func (e *PlatformError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+
+	message := "Collecting stats of " + e.Platform + " failed with " + e.Kind
+	if e.StatusCode != 0 {
+		message += " status " + strconv.Itoa(e.StatusCode)
+	}
+
+	return message + "."
+}
+
+// Unwrap returns the underlying error.
+func (e *PlatformError) Unwrap() error {
+	return e.Err
+}
+
+type platformErrorJSON struct {
+	Platform   string `json:"platform"`
+	Kind       string `json:"kind"`
+	StatusCode int    `json:"status,omitempty"`
+	Retryable  bool   `json:"retryable"`
+	Message    string `json:"message"`
+}
+
+// MarshalJSON renders error as object with platform, kind, status,
+// retryable flag and message.
+func (e *PlatformError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(platformErrorJSON{
+		Platform:   e.Platform,
+		Kind:       e.Kind,
+		StatusCode: e.StatusCode,
+		Retryable:  e.Retryable,
+		Message:    e.Error(),
+	})
+}
+
+// UnmarshalJSON reads error rendered by MarshalJSON. Underlying error is
+// restored only as message.
+func (e *PlatformError) UnmarshalJSON(body []byte) error {
+	var data platformErrorJSON
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	*e = PlatformError{
+		Platform:   data.Platform,
+		Kind:       data.Kind,
+		StatusCode: data.StatusCode,
+		Retryable:  data.Retryable,
+		Err:        errors.New(data.Message),
+	}
+
+	return nil
+}
+
+// statusError describes response of platform with unexpected status.
+func statusError(platform string, statusCode int, err error) *PlatformError {
+	return &PlatformError{
+		Platform:   platform,
+		Kind:       ErrorHTTPStatus,
+		StatusCode: statusCode,
+		Retryable:  statusCode == 429 || statusCode >= 500,
+		Err:        err,
+	}
+}
+
+// requestError describes error of sending request or reading response,
+// falling back to kind when it is neither cancellation, timeout nor proxy
+// error.
+func requestError(platform string, err error, kind string, opts Options) *PlatformError {
+	var netError net.Error
+	var opError *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		kind = ErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netError) && netError.Timeout()):
+		kind = ErrorTimeout
+	case errors.As(err, &opError) && opError.Op == "proxyconnect":
+		kind = ErrorProxy
+	case opts.Proxy != "" && strings.Contains(strings.ToLower(err.Error()), "proxy"):
+		kind = ErrorProxy
+	}
+
+	return &PlatformError{
+		Platform:  platform,
+		Kind:      kind,
+		Retryable: kind == ErrorTimeout || kind == ErrorNetwork,
+		Err:       err,
+	}
+}
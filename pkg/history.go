@@ -0,0 +1,183 @@
+package collector
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot is aggregated result of URL at a point in time.
+type Snapshot struct {
+	Time  time.Time `json:"time"`
+	URL   string    `json:"url"`
+	Total int64     `json:"total"`
+	// Counts holds count of every platform that succeeded.
+	Counts map[string]int64 `json:"counts"`
+}
+
+// Point is snapshot of history together with changes since previous one.
+type Point struct {
+	*Snapshot
+	// TotalDelta sums Deltas, so platforms failing or recovering between
+	// snapshots don't show up as change of total.
+	TotalDelta int64 `json:"total_delta"`
+	// Deltas holds changes of platforms that were in previous snapshot too.
+	Deltas map[string]int64 `json:"deltas"`
+}
+
+// History is time series of snapshots of URL, oldest first.
+type History struct {
+	URL    string   `json:"url"`
+	Points []*Point `json:"points"`
+}
+
+// NewSnapshot takes snapshot of result at given time.
+func NewSnapshot(result *Result, at time.Time) *Snapshot {
+	snapshot := &Snapshot{
+		Time:   at.UTC(),
+		URL:    result.URL,
+		Total:  result.Meta.Total,
+		Counts: map[string]int64{},
+	}
+
+	for name, platform := range result.Platforms {
+		if platform.Error == nil {
+			snapshot.Counts[name] = platform.Count
+		}
+	}
+
+	return snapshot
+}
+
+// HistoryStore keeps snapshots of results in a directory, one append only
+// file of JSON lines per URL.
+type HistoryStore struct {
+	// StripParams are query parameters ignored when URLs are matched to
+	// their history, as they are by cache. Defaults to DefaultStripParams.
+	StripParams []string
+
+	dir string
+	mu  sync.Mutex
+}
+
+// NewHistoryStore creates store in dir, creating directory when missing.
+func NewHistoryStore(dir string) (*HistoryStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &HistoryStore{dir: dir}, nil
+}
+
+func (h *HistoryStore) path(lookupURL string) string {
+	sum := sha1.Sum([]byte(Normalize(lookupURL, Options{StripParams: h.StripParams}.stripParams())))
+	return filepath.Join(h.dir, hex.EncodeToString(sum[:])+".jsonl")
+}
+
+// Record appends snapshot of result taken now to history of its URL.
+func (h *HistoryStore) Record(result *Result) error {
+	return h.Append(NewSnapshot(result, time.Now()))
+}
+
+// Append adds snapshot to history of its URL.
+func (h *HistoryStore) Append(snapshot *Snapshot) error {
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	file, err := os.OpenFile(h.path(snapshot.URL), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Snapshots returns snapshots of lookupURL taken at or after since, oldest
+// first. Lines that can't be read are skipped.
+func (h *HistoryStore) Snapshots(lookupURL string, since time.Time) ([]*Snapshot, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshots := []*Snapshot{}
+	file, err := os.Open(h.path(lookupURL))
+	if os.IsNotExist(err) {
+		return snapshots, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		snapshot := &Snapshot{}
+		if err := json.Unmarshal(scanner.Bytes(), snapshot); err != nil {
+			Logger.Warn("Reading history failed", "url", lookupURL, "error", err)
+			continue
+		}
+
+		if !snapshot.Time.Before(since) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, scanner.Err()
+}
+
+// History returns time series of lookupURL since given time with deltas
+// between consecutive snapshots.
+func (h *HistoryStore) History(lookupURL string, since time.Time) (*History, error) {
+	snapshots, err := h.Snapshots(lookupURL, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHistory(lookupURL, snapshots), nil
+}
+
+// NewHistory builds time series from snapshots ordered oldest first.
+func NewHistory(lookupURL string, snapshots []*Snapshot) *History {
+	history := &History{URL: lookupURL, Points: []*Point{}}
+	var previous *Snapshot
+	for _, snapshot := range snapshots {
+		point := &Point{Snapshot: snapshot, Deltas: map[string]int64{}}
+		if previous != nil {
+			for name, count := range snapshot.Counts {
+				if previousCount, ok := previous.Counts[name]; ok {
+					point.Deltas[name] = count - previousCount
+					point.TotalDelta += count - previousCount
+				}
+			}
+		}
+
+		history.Points = append(history.Points, point)
+		previous = snapshot
+	}
+
+	return history
+}
+
+// MarshalXML renders history as <history> element with the same structure
+// as its JSON.
+func (h *History) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name.Local = "history"
+	return encodeXMLJSON(e, start, h)
+}
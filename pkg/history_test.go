@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreSeries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socol-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, counts := range []map[string]int64{
+		{"facebook": 10},
+		{"facebook": 25, "reddit": 4},
+		{"facebook": 40, "reddit": 5},
+	} {
+		result := newResult("https://Example.com/a?utm_source=" + strconv.Itoa(i))
+		for name, count := range counts {
+			result.Platforms[name] = &PlatformResult{Name: name, Count: count}
+			result.Meta.Total += count
+		}
+		result.Platforms["failing"] = &PlatformResult{Name: "failing", Error: os.ErrNotExist}
+
+		if err := store.Append(NewSnapshot(result, start.Add(time.Duration(i)*time.Hour))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expected one history file, got %v", files)
+	}
+
+	history, err := store.History("https://example.com/a", start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history.Points) != 2 {
+		t.Fatalf("expected 2 points since second snapshot, got %d", len(history.Points))
+	}
+
+	last := history.Points[1]
+	if last.Total != 45 || last.TotalDelta != 16 || last.Deltas["facebook"] != 15 || last.Deltas["reddit"] != 1 {
+		t.Errorf("unexpected point %+v", last)
+	}
+
+	if _, ok := last.Counts["failing"]; ok {
+		t.Error("expected failed platforms to be left out")
+	}
+
+	history, _ = store.History("https://example.com/a", time.Time{})
+	if _, ok := history.Points[1].Deltas["reddit"]; ok || history.Points[1].Deltas["facebook"] != 15 {
+		t.Errorf("expected delta only for platforms of previous snapshot, got %v", history.Points[1].Deltas)
+	}
+
+	if history.Points[1].TotalDelta != 15 {
+		t.Errorf("expected reddit appearing not to count in total delta, got %d", history.Points[1].TotalDelta)
+	}
+}
@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Logger logs collections. It is silent unless LOG_LEVEL is set, when it
+// logs to stderr as NewLogger does with LOG_LEVEL and LOG_FORMAT.
+var Logger *slog.Logger
+
+type requestIDKey struct{}
+
+func init() {
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		Logger = slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	} else {
+		Logger = NewLogger(os.Stderr, logLevel, os.Getenv("LOG_FORMAT"))
+	}
+}
+
+// NewLogger creates logger writing to w records of level and above, one of
+// "debug", "info", "warn" and "error". Empty level means "info" and unknown
+// one "debug". Records are JSON objects when format is "json" and logfmt
+// lines otherwise.
+func NewLogger(w io.Writer, level string, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: parseLevel(level)}
+	if strings.ToLower(format) == "json" {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "", "info":
+		return slog.LevelInfo
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+
+	return slog.LevelDebug
+}
+
+// WithRequestID returns context whose log records carry request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns request ID of ctx or empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// LoggerFrom returns Logger with request ID of ctx.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return Logger.With("request_id", id)
+	}
+
+	return Logger
+}
@@ -0,0 +1,223 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Metadata is a property of page together with source it was taken from:
+// "opengraph", "json-ld", "twitter" or "html".
+type Metadata struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// metadataProperties are properties of unified metadata block.
+var metadataProperties = []string{
+	"title",
+	"description",
+	"image",
+	"url",
+	"site_name",
+	"type",
+	"author",
+	"published_time",
+	"modified_time",
+	"keywords",
+	"price",
+	"currency",
+	"brand",
+}
+
+// buildMetadata combines OpenGraph, JSON-LD, Twitter Card and standard
+// HTML properties of origin. Earlier sources take precedence.
+func buildMetadata(origin *Origin) map[string]*Metadata {
+	sources := []struct {
+		name   string
+		values map[string]string
+	}{
+		{"opengraph", openGraphProperties(origin)},
+		{"json-ld", jsonLDProperties(origin.JSONLD)},
+		{"twitter", twitterProperties(origin.Twitter)},
+		{"html", htmlProperties(origin.HTML)},
+	}
+
+	metadata := map[string]*Metadata{}
+	for _, property := range metadataProperties {
+		for _, source := range sources {
+			if value := strings.TrimSpace(source.values[property]); value != "" {
+				metadata[property] = &Metadata{Value: value, Source: source.name}
+				break
+			}
+		}
+	}
+
+	return metadata
+}
+
+func openGraphProperties(origin *Origin) map[string]string {
+	values := map[string]string{
+		"title":       origin.Title,
+		"description": origin.Description,
+		"url":         origin.URL,
+		"site_name":   origin.SiteName,
+		"type":        origin.Type,
+	}
+
+	if len(origin.Images) > 0 {
+		values["image"] = origin.Images[0].URL
+	}
+
+	if article := origin.Article; article != nil {
+		if len(article.Authors) > 0 {
+			author := article.Authors[0]
+			values["author"] = strings.TrimSpace(author.FirstName + " " + author.LastName)
+			if values["author"] == "" {
+				values["author"] = author.URL
+			}
+		}
+
+		if article.PublishedTime != nil {
+			values["published_time"] = article.PublishedTime.Format(time.RFC3339)
+		}
+
+		if article.ModifiedTime != nil {
+			values["modified_time"] = article.ModifiedTime.Format(time.RFC3339)
+		}
+
+		values["keywords"] = strings.Join(article.Tags, ", ")
+	}
+
+	return values
+}
+
+func twitterProperties(twitter map[string]string) map[string]string {
+	values := map[string]string{
+		"title":       twitter["title"],
+		"description": twitter["description"],
+		"image":       twitter["image"],
+		"site_name":   twitter["site"],
+		"author":      twitter["creator"],
+	}
+
+	if values["image"] == "" {
+		values["image"] = twitter["image:src"]
+	}
+
+	return values
+}
+
+func htmlProperties(html map[string]string) map[string]string {
+	return map[string]string{
+		"title":       html["title"],
+		"description": html["description"],
+		"author":      html["author"],
+		"keywords":    html["keywords"],
+	}
+}
+
+// jsonLDProperties reads properties of first schema.org item of a known
+// type, such as NewsArticle or Product.
+func jsonLDProperties(items []map[string]interface{}) map[string]string {
+	for _, item := range items {
+		itemType := jsonLDText(item["@type"])
+		switch itemType {
+		case "Article", "NewsArticle", "BlogPosting", "Report", "WebPage":
+			return map[string]string{
+				"title":          firstText(item["headline"], item["name"]),
+				"description":    jsonLDText(item["description"]),
+				"image":          jsonLDText(item["image"]),
+				"url":            jsonLDText(item["url"]),
+				"type":           itemType,
+				"author":         jsonLDText(item["author"]),
+				"site_name":      jsonLDText(item["publisher"]),
+				"published_time": jsonLDText(item["datePublished"]),
+				"modified_time":  jsonLDText(item["dateModified"]),
+				"keywords":       jsonLDText(item["keywords"]),
+			}
+		case "Product":
+			values := map[string]string{
+				"title":       jsonLDText(item["name"]),
+				"description": jsonLDText(item["description"]),
+				"image":       jsonLDText(item["image"]),
+				"url":         jsonLDText(item["url"]),
+				"type":        itemType,
+				"brand":       jsonLDText(item["brand"]),
+			}
+
+			offers := item["offers"]
+			if list, ok := offers.([]interface{}); ok && len(list) > 0 {
+				offers = list[0]
+			}
+
+			if offer, ok := offers.(map[string]interface{}); ok {
+				values["price"] = firstText(offer["price"], offer["lowPrice"])
+				values["currency"] = jsonLDText(offer["priceCurrency"])
+			}
+
+			return values
+		}
+	}
+
+	return map[string]string{}
+}
+
+// jsonLDText returns text of JSON-LD value. Objects are represented by
+// their name or url and lists by their first element.
+func jsonLDText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	case []interface{}:
+		if len(v) > 0 {
+			return jsonLDText(v[0])
+		}
+	case map[string]interface{}:
+		return firstText(v["name"], v["url"], v["@id"])
+	}
+
+	return ""
+}
+
+func firstText(values ...interface{}) string {
+	for _, value := range values {
+		if text := jsonLDText(value); text != "" {
+			return text
+		}
+	}
+
+	return ""
+}
+
+// parseJSONLD returns items of JSON-LD script, flattening lists and @graph.
+func parseJSONLD(script string) []map[string]interface{} {
+	var data interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(script)), &data); err != nil {
+		Logger.Debug("Skipping invalid JSON-LD", "platform", "origin", "error", err)
+		return nil
+	}
+
+	items := []map[string]interface{}{}
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			if graph, ok := v["@graph"]; ok {
+				collect(graph)
+				return
+			}
+			items = append(items, v)
+		}
+	}
+
+	collect(data)
+	return items
+}
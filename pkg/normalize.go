@@ -0,0 +1,194 @@
+package collector
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStripParams are tracking parameters removed from URLs by Normalize.
+// Patterns ending with * match parameters with that prefix.
+var DefaultStripParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"_ga",
+	"yclid",
+}
+
+// Normalize lowercases scheme and host, drops default ports, fragment and
+// query parameters matching stripParams. Remaining parameters are kept as
+// they were, in the same order. URLs that can't be parsed are returned
+// trimmed.
+func Normalize(rawURL string, stripParams []string) string {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || parsed.Host == "" {
+		return strings.TrimSpace(rawURL)
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	if (parsed.Scheme == "http" && strings.HasSuffix(parsed.Host, ":80")) ||
+		(parsed.Scheme == "https" && strings.HasSuffix(parsed.Host, ":443")) {
+		parsed.Host = parsed.Host[:strings.LastIndex(parsed.Host, ":")]
+	}
+
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+
+	params := []string{}
+	for _, param := range strings.Split(parsed.RawQuery, "&") {
+		name := strings.SplitN(param, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if param != "" && !matchesParam(name, stripParams) {
+			params = append(params, param)
+		}
+	}
+
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	parsed.ForceQuery = false
+	parsed.RawQuery = strings.Join(params, "&")
+	return parsed.String()
+}
+
+func matchesParam(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Variants returns rawURL followed by its variants with http and https
+// scheme, with and without "www." and with and without trailing slash.
+func Variants(rawURL string) []string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return []string{rawURL}
+	}
+
+	host := strings.TrimPrefix(parsed.Host, "www.")
+	path := strings.TrimSuffix(parsed.Path, "/")
+
+	variants, seen := []string{rawURL}, map[string]bool{rawURL: true}
+	for _, scheme := range []string{"https", "http"} {
+		for _, h := range []string{host, "www." + host} {
+			for _, p := range []string{path + "/", path} {
+				if p == "" {
+					continue
+				}
+
+				variant := *parsed
+				variant.Scheme, variant.Host, variant.Path = scheme, h, p
+				if s := variant.String(); !seen[s] {
+					seen[s] = true
+					variants = append(variants, s)
+				}
+			}
+		}
+	}
+
+	return variants
+}
+
+// canonicalURL picks URL that platforms are queried for from resolved URL,
+// canonical link and og:url of origin.
+func canonicalURL(resolved string, origin *Origin, opts Options) string {
+	canonical := resolved
+	if origin != nil {
+		for _, candidate := range []string{origin.Canonical, origin.URL} {
+			if candidate == "" {
+				continue
+			}
+
+			base, err := url.Parse(resolved)
+			reference, refErr := url.Parse(candidate)
+			if err == nil && refErr == nil {
+				if absolute := base.ResolveReference(reference); absolute.Scheme == "http" || absolute.Scheme == "https" {
+					canonical = absolute.String()
+					break
+				}
+			}
+		}
+	}
+
+	return Normalize(canonical, opts.stripParams())
+}
+
+// doVariantsRequest queries provider for every variant of lookup URL and
+// sums their counts, attempts and time queued. Result fails only when all
+// variants fail.
+func doVariantsRequest(ctx context.Context, provider Provider, variants []string, opts Options) *PlatformResult {
+	results := make([]*PlatformResult, len(variants))
+	var wg sync.WaitGroup
+	for i, variant := range variants {
+		wg.Add(1)
+		go func(i int, variant string) {
+			defer wg.Done()
+			results[i] = doRequest(ctx, provider, variant, opts)
+		}(i, variant)
+	}
+	wg.Wait()
+
+	var combined *PlatformResult
+	var firstError error
+	counts := map[string]int64{}
+	attempts, queuedIn := 0, time.Duration(0)
+	for i, result := range results {
+		attempts += result.Attempts
+		queuedIn += result.QueuedIn
+		if result.Error != nil {
+			if firstError == nil {
+				firstError = result.Error
+			}
+			continue
+		}
+
+		counts[variants[i]] = result.Count
+		if combined == nil {
+			combined = result
+			continue
+		}
+
+		combined.Count += result.Count
+		combined.FetchedIn = maxDuration(combined.FetchedIn, result.FetchedIn)
+		combined.CompletedIn = maxDuration(combined.CompletedIn, result.CompletedIn)
+	}
+
+	if combined == nil {
+		combined = &PlatformResult{Name: provider.Name(), Error: firstError}
+	} else {
+		combined.Variants = counts
+	}
+
+	combined.Attempts = attempts
+	combined.QueuedIn = queuedIn
+	return combined
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
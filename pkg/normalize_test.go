@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"HTTP://Example.COM":                              "http://example.com/",
+		"https://example.com:443/a?b=2&a=1#top":           "https://example.com/a?b=2&a=1",
+		"https://example.com/a?b=1&a=2&flag":              "https://example.com/a?b=1&a=2&flag",
+		"https://example.com/a?utm_source=x&b=1&flag":     "https://example.com/a?b=1&flag",
+		"https://example.com/a?utm_source=x&UTM_medium=y": "https://example.com/a",
+		"https://example.com/a?fbclid=1&id=5":             "https://example.com/a?id=5",
+		"not a url":                                       "not a url",
+	}
+
+	for input, expected := range cases {
+		if normalized := Normalize(input, DefaultStripParams); normalized != expected {
+			t.Errorf("expected %s for %s, got %s", expected, input, normalized)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	variants := Variants("https://example.com/a?id=1")
+	if len(variants) != 8 || variants[0] != "https://example.com/a?id=1" {
+		t.Errorf("unexpected variants %v", variants)
+	}
+
+	if variants := Variants("https://www.example.com/"); len(variants) != 4 {
+		t.Errorf("expected 4 variants of root, got %v", variants)
+	}
+}
+
+func TestCollectCanonicalVariants(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><link rel="canonical" href="/article/?utm_source=feed&id=1"></head></html>`))
+	}))
+	defer page.Close()
+
+	registry := NewRegistry(testPlatform("ok", server.URL+"/count"))
+	result, _ := Collect(context.Background(), page.URL+"/amp?utm_campaign=x", Options{
+		Registry:      registry,
+		Canonicalize:  true,
+		QueryVariants: true,
+	})
+
+	if expected := page.URL + "/article/?id=1"; result.Meta.LookupURL != expected {
+		t.Errorf("expected lookup URL %s, got %s", expected, result.Meta.LookupURL)
+	}
+
+	platform := result.Platforms["ok"]
+	if len(platform.Variants) != 8 || platform.Count != 24 || result.Meta.Total != 24 {
+		t.Errorf("expected 8 variants summed, got %d in %v", platform.Count, platform.Variants)
+	}
+
+	if platform.Attempts != 8 {
+		t.Errorf("expected attempts of all variants summed, got %d", platform.Attempts)
+	}
+}
+
+func TestCollectNormalizesLookupURL(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	registry := NewRegistry(testPlatform("ok", server.URL+"/count"))
+	result, _ := Collect(context.Background(), server.URL+"?utm_source=feed&b=2&a=1&flag#top", Options{Registry: registry})
+	if expected := server.URL + "/?b=2&a=1&flag"; result.Meta.LookupURL != expected {
+		t.Errorf("expected lookup URL %s, got %s", expected, result.Meta.LookupURL)
+	}
+
+	result, _ = Collect(context.Background(), server.URL+"/?utm_source=feed", Options{Registry: registry, StripParams: []string{}})
+	if expected := server.URL + "/?utm_source=feed"; result.Meta.LookupURL != expected {
+		t.Errorf("expected empty StripParams to keep parameters, got %s", result.Meta.LookupURL)
+	}
+}
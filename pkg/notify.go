@@ -0,0 +1,398 @@
+package collector
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kinds of rules.
+const (
+	// RuleAbove fires when count reaches threshold.
+	RuleAbove = "above"
+	// RuleDelta fires when count grows by threshold within window.
+	RuleDelta = "delta"
+	// RuleRate fires when count grows by threshold percent within window.
+	RuleRate = "rate"
+)
+
+// Rule describes when a webhook is notified about counts of URL.
+type Rule struct {
+	// Name identifies rule in notifications.
+	Name string `json:"name"`
+	// URL limits rule to a single URL. Rule applies to all URLs when empty.
+	URL string `json:"url,omitempty"`
+	// Metric is name of platform or "total" for total of all platforms.
+	Metric string `json:"metric"`
+	// Kind is one of RuleAbove, RuleDelta and RuleRate.
+	Kind      string  `json:"kind"`
+	Threshold float64 `json:"threshold"`
+	// Window is duration such as "1h" over which delta and rate are taken.
+	Window string `json:"window,omitempty"`
+	// Webhook is URL notified when rule fires. Defaults to Webhook of
+	// Notifier.
+	Webhook string `json:"webhook,omitempty"`
+
+	window time.Duration
+}
+
+// Notification is posted as JSON to webhook when rule fires.
+type Notification struct {
+	Rule      string    `json:"rule"`
+	URL       string    `json:"url"`
+	Metric    string    `json:"metric"`
+	Kind      string    `json:"kind"`
+	Threshold float64   `json:"threshold"`
+	Value     int64     `json:"value"`
+	Previous  int64     `json:"previous"`
+	Time      time.Time `json:"time"`
+	// Webhook is URL notification is posted to.
+	Webhook string `json:"-"`
+}
+
+// Notifier posts notifications to webhooks when counts of observed results
+// match rules. A rule fires once when its condition becomes true and again
+// only after it was false in between. Use NewNotifier to create it.
+type Notifier struct {
+	// Webhook is URL notified by rules without their own.
+	Webhook string
+	// Secret signs notifications with HMAC-SHA256 of body, sent in
+	// X-Socol-Signature header as "sha256=<hex>".
+	Secret string
+	// Client posts notifications. Defaults to client with 10 second timeout.
+	Client *http.Client
+	// Retries is number of times failed notification is sent again.
+	// Defaults to 3, negative value disables retries.
+	Retries int
+	// Backoff is wait before the first retry, doubled for every next one.
+	// Defaults to a second.
+	Backoff time.Duration
+	// DryRun logs notifications instead of sending them.
+	DryRun bool
+	// MaxURLs limits URLs whose samples are kept. Least recently observed
+	// URLs are forgotten first. Defaults to 10000.
+	MaxURLs int
+
+	rules []*Rule
+	mu    sync.Mutex
+	order *list.List
+	urls  map[string]*list.Element
+}
+
+// urlState holds samples of URL ordered by time and rules firing for it.
+type urlState struct {
+	url     string
+	samples []sample
+	firing  map[string]bool
+}
+
+type sample struct {
+	at     time.Time
+	values map[string]int64
+}
+
+// NewNotifier creates notifier with rules, validating them.
+func NewNotifier(rules []*Rule, webhook string) (*Notifier, error) {
+	for i, rule := range rules {
+		if rule.Metric == "" {
+			return nil, errors.New("Missing metric of rule " + strconv.Itoa(i) + ".")
+		}
+
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s %s %v", rule.Metric, rule.Kind, rule.Threshold)
+		}
+
+		switch rule.Kind {
+		case RuleAbove:
+		case RuleDelta, RuleRate:
+			window, err := time.ParseDuration(rule.Window)
+			if err != nil || window <= 0 {
+				return nil, errors.New("Invalid window of rule " + rule.Name + ".")
+			}
+			rule.window = window
+		default:
+			return nil, errors.New("Unknown kind of rule " + rule.Name + ".")
+		}
+
+		if rule.Webhook == "" && webhook == "" {
+			return nil, errors.New("Missing webhook of rule " + rule.Name + ".")
+		}
+	}
+
+	return &Notifier{
+		Webhook: webhook,
+		rules:   rules,
+		order:   list.New(),
+		urls:    map[string]*list.Element{},
+	}, nil
+}
+
+// Observe evaluates rules against result collected at given time and
+// notifies webhooks of rules that fired. It returns fired notifications and
+// the first error of sending them.
+func (n *Notifier) Observe(ctx context.Context, result *Result, at time.Time) ([]*Notification, error) {
+	values := metricValues(result)
+	notifications := n.evaluate(result.URL, values, at)
+
+	var firstError error
+	for _, notification := range notifications {
+		if err := n.Send(ctx, notification); err != nil {
+			LoggerFrom(ctx).Warn("Notifying webhook failed", "webhook", notification.Webhook, "rule", notification.Rule, "url", notification.URL, "error", err)
+			if firstError == nil {
+				firstError = err
+			}
+		}
+	}
+
+	return notifications, firstError
+}
+
+// evaluate records values of URL and returns notifications of rules whose
+// condition has just become true.
+func (n *Notifier) evaluate(lookupURL string, values map[string]int64, at time.Time) []*Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	rules := []*Rule{}
+	for _, rule := range n.rules {
+		if rule.URL == "" || Normalize(rule.URL, nil) == Normalize(lookupURL, nil) {
+			rules = append(rules, rule)
+		}
+	}
+
+	notifications := []*Notification{}
+	if len(rules) == 0 {
+		return notifications
+	}
+
+	state := n.state(lookupURL)
+	samples := state.samples
+	for _, rule := range rules {
+		value, ok := values[rule.Metric]
+		if !ok {
+			continue
+		}
+
+		previous, met := value, false
+		switch rule.Kind {
+		case RuleAbove:
+			met = float64(value) >= rule.Threshold
+		case RuleDelta, RuleRate:
+			baseline, found := baselineOf(samples, rule.Metric, at.Add(-rule.window))
+			if !found {
+				break
+			}
+
+			previous = baseline
+			if rule.Kind == RuleDelta {
+				met = float64(value-baseline) >= rule.Threshold
+			} else if baseline > 0 {
+				met = float64(value-baseline)/float64(baseline)*100 >= rule.Threshold
+			}
+		}
+
+		if met && !state.firing[rule.Name] {
+			webhook := rule.Webhook
+			if webhook == "" {
+				webhook = n.Webhook
+			}
+
+			notifications = append(notifications, &Notification{
+				Rule:      rule.Name,
+				URL:       lookupURL,
+				Metric:    rule.Metric,
+				Kind:      rule.Kind,
+				Threshold: rule.Threshold,
+				Value:     value,
+				Previous:  previous,
+				Time:      at.UTC(),
+				Webhook:   webhook,
+			})
+		}
+		state.firing[rule.Name] = met
+	}
+
+	state.samples = insertSample(pruneSamples(samples, at.Add(-n.maxWindow())), sample{at: at, values: values})
+	return notifications
+}
+
+// state returns state of URL, forgetting least recently observed URLs
+// beyond MaxURLs. It is called with mu held.
+func (n *Notifier) state(lookupURL string) *urlState {
+	if element, ok := n.urls[lookupURL]; ok {
+		n.order.MoveToFront(element)
+		return element.Value.(*urlState)
+	}
+
+	state := &urlState{url: lookupURL, firing: map[string]bool{}}
+	n.urls[lookupURL] = n.order.PushFront(state)
+
+	maxURLs := n.MaxURLs
+	if maxURLs <= 0 {
+		maxURLs = 10000
+	}
+
+	for n.order.Len() > maxURLs {
+		oldest := n.order.Back()
+		n.order.Remove(oldest)
+		delete(n.urls, oldest.Value.(*urlState).url)
+	}
+
+	return state
+}
+
+// insertSample adds s to samples keeping them ordered by time, as results
+// may be observed out of order.
+func insertSample(samples []sample, s sample) []sample {
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(s.at) })
+	samples = append(samples, sample{})
+	copy(samples[i+1:], samples[i:])
+	samples[i] = s
+	return samples
+}
+
+// baselineOf returns the oldest value of metric sampled at or after since.
+func baselineOf(samples []sample, metric string, since time.Time) (int64, bool) {
+	for _, s := range samples {
+		if value, ok := s.values[metric]; ok && !s.at.Before(since) {
+			return value, true
+		}
+	}
+
+	return 0, false
+}
+
+// pruneSamples drops samples taken before since.
+func pruneSamples(samples []sample, since time.Time) []sample {
+	kept := []sample{}
+	for _, s := range samples {
+		if !s.at.Before(since) {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
+
+func (n *Notifier) maxWindow() time.Duration {
+	window := time.Duration(0)
+	for _, rule := range n.rules {
+		if rule.window > window {
+			window = rule.window
+		}
+	}
+
+	return window
+}
+
+// metricValues returns counts of successful platforms and "total". Total is
+// left out when any platform failed, as it would drop with the failure.
+func metricValues(result *Result) map[string]int64 {
+	values := map[string]int64{}
+	failed := false
+	for name, platform := range result.Platforms {
+		if platform.Error == nil {
+			values[name] = platform.Count
+		} else {
+			failed = true
+		}
+	}
+
+	if !failed {
+		values["total"] = result.Meta.Total
+	}
+
+	return values
+}
+
+// Send posts notification to its webhook, retrying failed attempts with
+// backoff. Client errors other than 429 are not retried.
+func (n *Notifier) Send(ctx context.Context, notification *Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	if n.DryRun {
+		LoggerFrom(ctx).Info("Skipping notification in dry run", "webhook", notification.Webhook, "notification", string(body))
+		return nil
+	}
+
+	retries := n.Retries
+	if retries == 0 {
+		retries = 3
+	}
+
+	backoff := n.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryable, err := n.post(ctx, notification.Webhook, body)
+		if err == nil || !retryable || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, webhook string, body []byte) (bool, error) {
+	request, err := http.NewRequest("POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "socol")
+	if n.Secret != "" {
+		request.Header.Set("X-Socol-Signature", "sha256="+Sign(n.Secret, body))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	retryable := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retryable, errors.New("Got non OK HTTP status at " + response.Status + "-" + webhook)
+}
+
+// Sign returns hex encoded HMAC-SHA256 of body with secret, as sent in
+// X-Socol-Signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
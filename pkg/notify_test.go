@@ -0,0 +1,189 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookStandIn struct {
+	mu            sync.Mutex
+	notifications []*Notification
+	signed        []bool
+	failures      int
+}
+
+func (s *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	notification := &Notification{}
+	json.Unmarshal(body, notification)
+	s.notifications = append(s.notifications, notification)
+	s.signed = append(s.signed, r.Header.Get("X-Socol-Signature") == "sha256="+Sign("secret", body))
+}
+
+func resultWithCounts(counts map[string]int64) *Result {
+	result := newResult("https://example.com/article")
+	for name, count := range counts {
+		result.Platforms[name] = &PlatformResult{Name: name, Count: count}
+		result.Meta.Total += count
+	}
+
+	return result
+}
+
+func TestNotifierFiresRules(t *testing.T) {
+	standIn := &webhookStandIn{failures: 2}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	notifier, err := NewNotifier([]*Rule{
+		{Name: "viral", Metric: "facebook", Kind: RuleAbove, Threshold: 1000},
+		{Name: "growing", URL: "https://EXAMPLE.com/article", Metric: "total", Kind: RuleRate, Threshold: 50, Window: "1h"},
+	}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.Secret = "secret"
+	notifier.Backoff = time.Millisecond
+
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		counts map[string]int64
+		fired  []string
+	}{
+		{map[string]int64{"facebook": 800, "reddit": 200}, nil},
+		{map[string]int64{"facebook": 1200, "reddit": 200}, []string{"viral"}},
+		{map[string]int64{"facebook": 1300, "reddit": 300}, []string{"growing"}},
+		{map[string]int64{"facebook": 1400, "reddit": 300}, nil},
+	}
+
+	for i, step := range steps {
+		fired, err := notifier.Observe(context.Background(), resultWithCounts(step.counts), start.Add(time.Duration(i)*20*time.Minute))
+		if err != nil {
+			t.Errorf("step %d: unexpected error %v", i, err)
+		}
+
+		if len(fired) != len(step.fired) {
+			t.Errorf("step %d: expected %v to fire, got %d notifications", i, step.fired, len(fired))
+			continue
+		}
+
+		for j, name := range step.fired {
+			if fired[j].Rule != name {
+				t.Errorf("step %d: expected %s to fire, got %s", i, name, fired[j].Rule)
+			}
+		}
+	}
+
+	if len(standIn.notifications) != 2 {
+		t.Fatalf("expected 2 notifications delivered after retries, got %d", len(standIn.notifications))
+	}
+
+	if growing := standIn.notifications[1]; growing.Previous != 1000 || growing.Value != 1600 {
+		t.Errorf("expected growth from 1000 to 1600, got %+v", growing)
+	}
+
+	for i, signed := range standIn.signed {
+		if !signed {
+			t.Errorf("expected notification %d to be signed", i)
+		}
+	}
+}
+
+func TestNotifierDryRun(t *testing.T) {
+	standIn := &webhookStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	notifier, _ := NewNotifier([]*Rule{{Metric: "total", Kind: RuleAbove, Threshold: 1}}, server.URL)
+	notifier.DryRun = true
+
+	fired, err := notifier.Observe(context.Background(), resultWithCounts(map[string]int64{"facebook": 5}), time.Now())
+	if err != nil || len(fired) != 1 || fired[0].Rule != "total above 1" {
+		t.Errorf("expected rule to fire, got %v %v", fired, err)
+	}
+
+	if len(standIn.notifications) != 0 {
+		t.Error("expected dry run not to send notifications")
+	}
+}
+
+func TestNotifierIgnoresTotalOfFailedResults(t *testing.T) {
+	notifier, _ := NewNotifier([]*Rule{{Name: "popular", Metric: "total", Kind: RuleAbove, Threshold: 100}}, "http://127.0.0.1/hook")
+	notifier.DryRun = true
+
+	failed := resultWithCounts(map[string]int64{"facebook": 20})
+	failed.Platforms["reddit"] = &PlatformResult{Name: "reddit", Error: &PlatformError{Platform: "reddit", Kind: ErrorTimeout}}
+
+	start := time.Now()
+	for i, result := range []*Result{
+		resultWithCounts(map[string]int64{"facebook": 20, "reddit": 90}),
+		failed,
+		resultWithCounts(map[string]int64{"facebook": 20, "reddit": 95}),
+	} {
+		fired, _ := notifier.Observe(context.Background(), result, start.Add(time.Duration(i)*time.Minute))
+		if expected := i == 0; (len(fired) == 1) != expected {
+			t.Errorf("step %d: expected rule to fire %v, got %d notifications", i, expected, len(fired))
+		}
+	}
+}
+
+func TestNotifierForgetsLeastRecentURLs(t *testing.T) {
+	notifier, _ := NewNotifier([]*Rule{{Metric: "facebook", Kind: RuleDelta, Threshold: 10, Window: "1h"}}, "http://127.0.0.1/hook")
+	notifier.DryRun = true
+	notifier.MaxURLs = 2
+
+	for _, lookupURL := range []string{"https://a.com/", "https://b.com/", "https://a.com/", "https://c.com/"} {
+		result := resultWithCounts(map[string]int64{"facebook": 1})
+		result.URL = lookupURL
+		notifier.Observe(context.Background(), result, time.Now())
+	}
+
+	if len(notifier.urls) != 2 || notifier.urls["https://b.com/"] != nil {
+		t.Errorf("expected only a.com and c.com to be kept, got %d URLs", len(notifier.urls))
+	}
+}
+
+func TestNotifierOrdersLateResults(t *testing.T) {
+	notifier, _ := NewNotifier([]*Rule{{Name: "growing", Metric: "facebook", Kind: RuleDelta, Threshold: 100, Window: "1h"}}, "http://127.0.0.1/hook")
+	notifier.DryRun = true
+
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	notifier.Observe(context.Background(), resultWithCounts(map[string]int64{"facebook": 200}), start.Add(30*time.Minute))
+	notifier.Observe(context.Background(), resultWithCounts(map[string]int64{"facebook": 100}), start)
+
+	fired, _ := notifier.Observe(context.Background(), resultWithCounts(map[string]int64{"facebook": 210}), start.Add(40*time.Minute))
+	if len(fired) != 1 || fired[0].Previous != 100 {
+		t.Errorf("expected growth from the oldest sample of 100, got %+v", fired)
+	}
+}
+
+func TestNewNotifierValidatesRules(t *testing.T) {
+	for _, rule := range []*Rule{
+		{Kind: RuleAbove},
+		{Metric: "total", Kind: "sometimes"},
+		{Metric: "total", Kind: RuleDelta},
+	} {
+		if _, err := NewNotifier([]*Rule{rule}, "http://127.0.0.1/"); err == nil {
+			t.Errorf("expected %+v to be rejected", rule)
+		}
+	}
+
+	if _, err := NewNotifier([]*Rule{{Metric: "total", Kind: RuleAbove}}, ""); err == nil {
+		t.Error("expected rule without webhook to be rejected")
+	}
+}
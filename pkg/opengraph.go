@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dyatlov/go-opengraph/opengraph"
+)
+
+// Image is og:image of page.
+type Image struct {
+	URL       string `json:"url,omitempty"`
+	SecureURL string `json:"secure_url,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Width     uint64 `json:"width,omitempty"`
+	Height    uint64 `json:"height,omitempty"`
+}
+
+// Video is og:video of page.
+type Video struct {
+	URL       string `json:"url,omitempty"`
+	SecureURL string `json:"secure_url,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Width     uint64 `json:"width,omitempty"`
+	Height    uint64 `json:"height,omitempty"`
+}
+
+// Audio is og:audio of page.
+type Audio struct {
+	URL       string `json:"url,omitempty"`
+	SecureURL string `json:"secure_url,omitempty"`
+	Type      string `json:"type,omitempty"`
+}
+
+// Profile is a person, either author of article or book or the page itself.
+type Profile struct {
+	URL       string `json:"url,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Gender    string `json:"gender,omitempty"`
+}
+
+// Article holds article:* properties of page.
+type Article struct {
+	PublishedTime  *time.Time `json:"published_time,omitempty"`
+	ModifiedTime   *time.Time `json:"modified_time,omitempty"`
+	ExpirationTime *time.Time `json:"expiration_time,omitempty"`
+	Section        string     `json:"section,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	Authors        []*Profile `json:"authors,omitempty"`
+}
+
+// Book holds book:* properties of page.
+type Book struct {
+	ISBN        string     `json:"isbn,omitempty"`
+	ReleaseDate *time.Time `json:"release_date,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Authors     []*Profile `json:"authors,omitempty"`
+}
+
+// timeLayouts are tried for times that are not valid RFC 3339.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// fillOpenGraph copies data parsed by go-opengraph to origin.
+func fillOpenGraph(origin *Origin, og *opengraph.OpenGraph) {
+	origin.Type = og.Type
+	origin.URL = og.URL
+	origin.Title = og.Title
+	origin.Description = og.Description
+	origin.Determiner = og.Determiner
+	origin.SiteName = og.SiteName
+	origin.Locale = og.Locale
+	origin.LocalesAlternate = og.LocalesAlternate
+
+	for _, image := range og.Images {
+		origin.Images = append(origin.Images, &Image{
+			URL:       image.URL,
+			SecureURL: image.SecureURL,
+			Type:      image.Type,
+			Width:     image.Width,
+			Height:    image.Height,
+		})
+	}
+
+	for _, video := range og.Videos {
+		origin.Videos = append(origin.Videos, &Video{
+			URL:       video.URL,
+			SecureURL: video.SecureURL,
+			Type:      video.Type,
+			Width:     video.Width,
+			Height:    video.Height,
+		})
+	}
+
+	for _, audio := range og.Audios {
+		origin.Audios = append(origin.Audios, &Audio{
+			URL:       audio.URL,
+			SecureURL: audio.SecureURL,
+			Type:      audio.Type,
+		})
+	}
+
+	if og.Article != nil {
+		origin.Article = &Article{
+			PublishedTime:  og.Article.PublishedTime,
+			ModifiedTime:   og.Article.ModifiedTime,
+			ExpirationTime: og.Article.ExpirationTime,
+			Section:        og.Article.Section,
+			Tags:           og.Article.Tags,
+			Authors:        convertProfiles(og.Article.Authors),
+		}
+	}
+
+	if og.Book != nil {
+		origin.Book = &Book{
+			ISBN:        og.Book.ISBN,
+			ReleaseDate: og.Book.ReleaseDate,
+			Tags:        og.Book.Tags,
+			Authors:     convertProfiles(og.Book.Authors),
+		}
+	}
+
+	if og.Profile != nil {
+		origin.Profile = convertProfile(og.Profile)
+	}
+}
+
+// fillMissingOpenGraph adds properties that go-opengraph doesn't read:
+// audios, article section and author URLs and times that are not RFC 3339.
+func fillMissingOpenGraph(origin *Origin, metas []map[string]string) {
+	for _, meta := range metas {
+		property, content := meta["property"], strings.TrimSpace(meta["content"])
+		if content == "" {
+			continue
+		}
+
+		switch property {
+		case "og:audio", "og:audio:url":
+			if property == "og:audio" || len(origin.Audios) == 0 {
+				origin.Audios = append(origin.Audios, &Audio{})
+			}
+			origin.Audios[len(origin.Audios)-1].URL = content
+		case "og:audio:secure_url":
+			if len(origin.Audios) > 0 {
+				origin.Audios[len(origin.Audios)-1].SecureURL = content
+			}
+		case "og:audio:type":
+			if len(origin.Audios) > 0 {
+				origin.Audios[len(origin.Audios)-1].Type = content
+			}
+		}
+
+		if origin.Type != "article" || !strings.HasPrefix(property, "article:") {
+			continue
+		}
+
+		if origin.Article == nil {
+			origin.Article = &Article{}
+		}
+
+		switch property {
+		case "article:section":
+			origin.Article.Section = content
+		case "article:author":
+			// go-opengraph builds author from article:author:* properties
+			// only, so URL belongs to that author unless it already has one.
+			authors := origin.Article.Authors
+			if last := len(authors) - 1; last >= 0 && authors[last].URL == "" {
+				authors[last].URL = content
+			} else {
+				origin.Article.Authors = append(authors, &Profile{URL: content})
+			}
+		case "article:published_time":
+			fillTime(&origin.Article.PublishedTime, content)
+		case "article:modified_time":
+			fillTime(&origin.Article.ModifiedTime, content)
+		case "article:expiration_time":
+			fillTime(&origin.Article.ExpirationTime, content)
+		}
+	}
+
+	if origin.Book != nil && origin.Book.ReleaseDate == nil {
+		for _, meta := range metas {
+			if meta["property"] == "book:release_date" {
+				fillTime(&origin.Book.ReleaseDate, meta["content"])
+			}
+		}
+	}
+}
+
+func fillTime(target **time.Time, value string) {
+	if *target != nil {
+		return
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			*target = &t
+			return
+		}
+	}
+}
+
+func convertProfiles(profiles []*opengraph.Profile) []*Profile {
+	converted := []*Profile{}
+	for _, profile := range profiles {
+		converted = append(converted, convertProfile(profile))
+	}
+
+	return converted
+}
+
+func convertProfile(profile *opengraph.Profile) *Profile {
+	return &Profile{
+		FirstName: profile.FirstName,
+		LastName:  profile.LastName,
+		Username:  profile.Username,
+		Gender:    profile.Gender,
+	}
+}
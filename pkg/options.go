@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configure a single collection.
+type Options struct {
+	// Platforms limits collection to platforms with given names. All
+	// registered platforms are queried when empty.
+	Platforms []string
+	// Proxy is URL of proxy used for outbound requests.
+	Proxy string
+	// Timeout bounds each outbound request. Defaults to 4 seconds.
+	Timeout time.Duration
+	// Deadline bounds the whole collection, including retries and waits for
+	// rate limits. Defaults to 3 times Timeout, negative value disables it.
+	Deadline time.Duration
+	// UserAgent overrides User-Agent header of outbound requests.
+	UserAgent string
+	// InsecureSkipVerify disables verification of server certificates.
+	InsecureSkipVerify bool
+	// InsecurePlatforms disables verification of server certificates only
+	// for platforms with given names.
+	InsecurePlatforms []string
+	// CAFile is path to PEM encoded certificates trusted in addition to
+	// system roots, e.g. of a corporate proxy.
+	CAFile string
+	// HTTPClient is used for outbound requests instead of a client built
+	// from Proxy and TLS settings.
+	HTTPClient *http.Client
+	// Transports pools connections between collections. Defaults to
+	// DefaultTransports.
+	Transports *Transports
+	// Registry holds providers to query. Defaults to DefaultRegistry.
+	Registry *Registry
+	// Canonicalize queries platforms for canonical URL of page, taken from
+	// <link rel="canonical"> or og:url, instead of resolved URL. Either is
+	// normalized and stripped of StripParams.
+	Canonicalize bool
+	// StripParams are query parameters removed from URL platforms are
+	// queried for. Defaults to DefaultStripParams, empty slice keeps all.
+	StripParams []string
+	// QueryVariants queries platforms for http/https, www and trailing slash
+	// variants of URL and sums their counts.
+	QueryVariants bool
+	// MaxRedirects limits redirects followed when resolving looked up URL.
+	// Defaults to 10, negative value disables following redirects.
+	MaxRedirects int
+	// Retry is policy of retrying failed requests to platforms. Requests
+	// are attempted once by default.
+	Retry RetryPolicy
+	// PlatformRetry overrides Retry for platforms with given names.
+	PlatformRetry map[string]RetryPolicy
+	// RateLimiter throttles requests to platforms. Defaults to
+	// DefaultRateLimiter.
+	RateLimiter *RateLimiter
+}
+
+func (opts Options) timeout() time.Duration {
+	if opts.Timeout <= 0 {
+		return globalTimeout
+	}
+
+	return opts.Timeout
+}
+
+func (opts Options) deadline() time.Duration {
+	if opts.Deadline == 0 {
+		return 3 * opts.timeout()
+	}
+
+	return opts.Deadline
+}
+
+func (opts Options) userAgent() string {
+	if opts.UserAgent != "" {
+		return opts.UserAgent
+	}
+
+	return strings.Join([]string{"Mozilla/5.0 (socol) ", strconv.Itoa(rand.Intn(1000))}, " ")
+}
+
+func (opts Options) registry() *Registry {
+	if opts.Registry == nil {
+		return DefaultRegistry
+	}
+
+	return opts.Registry
+}
+
+func (opts Options) rateLimiter() *RateLimiter {
+	if opts.RateLimiter == nil {
+		return DefaultRateLimiter
+	}
+
+	return opts.RateLimiter
+}
+
+func (opts Options) transports() *Transports {
+	if opts.Transports == nil {
+		return DefaultTransports
+	}
+
+	return opts.Transports
+}
+
+// forPlatform returns options used for requests to platform with name.
+func (opts Options) forPlatform(name string) Options {
+	for _, insecure := range opts.InsecurePlatforms {
+		if insecure == name {
+			opts.InsecureSkipVerify = true
+		}
+	}
+
+	return opts
+}
+
+func (opts Options) maxRedirects() int {
+	if opts.MaxRedirects == 0 {
+		return defaultMaxRedirects
+	}
+
+	if opts.MaxRedirects < 0 {
+		return 0
+	}
+
+	return opts.MaxRedirects
+}
+
+func (opts Options) stripParams() []string {
+	if opts.StripParams == nil {
+		return DefaultStripParams
+	}
+
+	return opts.StripParams
+}
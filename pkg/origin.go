@@ -1,38 +1,129 @@
 package collector
 
 import (
+	"bytes"
 	"github.com/dyatlov/go-opengraph/opengraph"
-	"github.com/fatih/structs"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"reflect"
+	"strings"
 )
 
-func Origin() Platform {
-	return Platform{
-		enabled:  true,
-		name:     "origin",
-		statsURL: "%s",
-		parseWith: func(r *http.Response) (Stat, error) {
-			og := opengraph.NewOpenGraph()
-			err := og.ProcessHTML(r.Body)
-			if err != nil {
-				return Stat{}, err
+// maxOriginSize limits how much of looked up page is read.
+const maxOriginSize = 2 << 20
+
+// htmlMetaNames are names of standard <meta> tags kept in origin.
+var htmlMetaNames = map[string]bool{
+	"description":   true,
+	"keywords":      true,
+	"news_keywords": true,
+	"author":        true,
+	"publisher":     true,
+	"robots":        true,
+}
+
+// page holds parts of looked up page that origin is built from.
+type page struct {
+	metas  []map[string]string
+	links  []map[string]string
+	title  string
+	jsonLD []string
+}
+
+func parseOrigin(r *http.Response) (*Origin, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxOriginSize))
+	if err != nil {
+		return nil, err
+	}
+
+	og := opengraph.NewOpenGraph()
+	err = og.ProcessHTML(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	scanned := scanPage(body)
+	origin := &Origin{Twitter: map[string]string{}, HTML: map[string]string{}}
+	fillOpenGraph(origin, og)
+	fillMissingOpenGraph(origin, scanned.metas)
+
+	for _, link := range scanned.links {
+		if strings.ToLower(strings.TrimSpace(link["rel"])) == "canonical" && link["href"] != "" {
+			origin.Canonical = strings.TrimSpace(link["href"])
+			break
+		}
+	}
+
+	for _, meta := range scanned.metas {
+		name := strings.ToLower(strings.TrimSpace(meta["name"]))
+		if name == "" {
+			name = strings.ToLower(strings.TrimSpace(meta["property"]))
+		}
+
+		content := strings.TrimSpace(meta["content"])
+		if content == "" {
+			continue
+		}
+
+		if strings.HasPrefix(name, "twitter:") {
+			if key := strings.TrimPrefix(name, "twitter:"); origin.Twitter[key] == "" {
+				origin.Twitter[key] = content
 			}
+		} else if htmlMetaNames[name] && origin.HTML[name] == "" {
+			origin.HTML[name] = content
+		}
+	}
 
-			data := map[string]interface{}{}
-			asMap := structs.Map(og)
-			for k, v := range asMap {
-				if v != nil && v != "" {
-					val := reflect.ValueOf(v)
-					if val.Kind() == reflect.Ptr || val.Kind() == reflect.Slice || val.Kind() == reflect.Map {
-						// TODO: Do something if its pointer
-					} else {
-						data[k] = v
-					}
-				}
+	if scanned.title != "" {
+		origin.HTML["title"] = scanned.title
+	}
+
+	for _, script := range scanned.jsonLD {
+		origin.JSONLD = append(origin.JSONLD, parseJSONLD(script)...)
+	}
+
+	origin.Metadata = buildMetadata(origin)
+	return origin, nil
+}
+
+// scanPage collects attributes of <meta> and <link> tags, <title> and
+// JSON-LD scripts of page.
+func scanPage(body []byte) page {
+	scanned := page{}
+	inTitle, inJSONLD := false, false
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return scanned
+		case html.TextToken:
+			if inTitle && scanned.title == "" {
+				scanned.title = strings.TrimSpace(string(z.Text()))
+			} else if inJSONLD {
+				scanned.jsonLD = append(scanned.jsonLD, string(z.Text()))
+			}
+		case html.EndTagToken:
+			inTitle, inJSONLD = false, false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			attrs := map[string]string{}
+			for _, attr := range token.Attr {
+				attrs[attr.Key] = attr.Val
 			}
 
-			return Stat{data: data}, nil
-		},
+			switch token.DataAtom {
+			case atom.Meta:
+				scanned.metas = append(scanned.metas, attrs)
+			case atom.Link:
+				scanned.links = append(scanned.links, attrs)
+			case atom.Title:
+				inTitle = token.Type == html.StartTagToken
+			case atom.Script:
+				inJSONLD = token.Type == html.StartTagToken &&
+					strings.ToLower(strings.TrimSpace(attrs["type"])) == "application/ld+json"
+			}
+		}
 	}
 }
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+const articlePage = `<html><head>
+<meta property="og:type" content="article" />
+<meta property="og:title" content="Go 1.7 is released" />
+<meta property="og:image" content="https://example.com/a.png" />
+<meta property="og:image:width" content="640" />
+<meta property="og:audio" content="https://example.com/a.mp3" />
+<meta property="og:audio:type" content="audio/mpeg" />
+<meta property="article:published_time" content="2016-08-15" />
+<meta property="article:section" content="Releases" />
+<meta property="article:tag" content="go" />
+<meta property="article:author" content="https://example.com/gopher" />
+</head><body></body></html>`
+
+func TestParseOriginReadsOpenGraphObjects(t *testing.T) {
+	origin, err := parseOrigin(&http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(articlePage))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(origin.Images) != 1 || origin.Images[0].Width != 640 {
+		t.Errorf("unexpected images %+v", origin.Images)
+	}
+
+	if len(origin.Audios) != 1 || origin.Audios[0].Type != "audio/mpeg" {
+		t.Errorf("unexpected audios %+v", origin.Audios)
+	}
+
+	article := origin.Article
+	if article == nil || article.PublishedTime == nil || article.Section != "Releases" ||
+		len(article.Tags) != 1 || len(article.Authors) != 1 || article.Authors[0].URL != "https://example.com/gopher" {
+		t.Fatalf("unexpected article %+v", article)
+	}
+
+	body, _ := json.Marshal(origin)
+	decoded := &Origin{}
+	if err := json.Unmarshal(body, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Article == nil || !decoded.Article.PublishedTime.Equal(*article.PublishedTime) || len(decoded.Images) != 1 {
+		t.Errorf("expected origin to survive JSON, got %s", body)
+	}
+}
+
+func TestParseOriginMergesAuthorURLAndName(t *testing.T) {
+	page := `<html><head>
+<meta property="og:type" content="article" />
+<meta property="article:author" content="https://example.com/rob" />
+<meta property="article:author:first_name" content="Rob" />
+<meta property="article:author:last_name" content="Pike" />
+</head></html>`
+
+	origin, err := parseOrigin(&http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(page))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if origin.Article == nil || len(origin.Article.Authors) != 1 {
+		t.Fatalf("expected one author, got %+v", origin.Article)
+	}
+
+	if author := origin.Article.Authors[0]; author.URL != "https://example.com/rob" || author.FirstName != "Rob" || author.LastName != "Pike" {
+		t.Errorf("expected URL and name of the same author, got %+v", author)
+	}
+}
+
+const publisherPage = `<html><head>
+<title>Gophers &amp; friends</title>
+<meta name="description" content="All about gophers." />
+<meta name="twitter:card" content="summary_large_image" />
+<meta name="twitter:image" content="https://example.com/gopher.png" />
+<meta property="og:site_name" content="Gopher News" />
+</head><body>
+<script type="application/ld+json">
+{"@context": "https://schema.org", "@graph": [
+  {"@type": "NewsArticle", "headline": "Gophers everywhere", "datePublished": "2016-08-15T10:00:00Z",
+   "author": [{"@type": "Person", "name": "Rob"}]}
+]}
+</script>
+<script type="application/ld+json">{"broken": </script>
+</body></html>`
+
+func TestParseOriginCombinesMetadata(t *testing.T) {
+	origin, err := parseOrigin(&http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(publisherPage))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if origin.Twitter["card"] != "summary_large_image" || origin.HTML["title"] != "Gophers & friends" {
+		t.Errorf("unexpected twitter %v and html %v", origin.Twitter, origin.HTML)
+	}
+
+	if len(origin.JSONLD) != 1 {
+		t.Fatalf("expected one JSON-LD item, got %v", origin.JSONLD)
+	}
+
+	expected := map[string]Metadata{
+		"title":          {"Gophers everywhere", "json-ld"},
+		"description":    {"All about gophers.", "html"},
+		"image":          {"https://example.com/gopher.png", "twitter"},
+		"site_name":      {"Gopher News", "opengraph"},
+		"author":         {"Rob", "json-ld"},
+		"published_time": {"2016-08-15T10:00:00Z", "json-ld"},
+	}
+
+	for property, value := range expected {
+		if metadata := origin.Metadata[property]; metadata == nil || *metadata != value {
+			t.Errorf("expected %s to be %+v, got %+v", property, value, metadata)
+		}
+	}
+}
+
+func TestParseOriginUnescapesTitleOnce(t *testing.T) {
+	page := `<html><head><title>Escaping &amp;lt;tags&amp;gt;</title></head></html>`
+	origin, err := parseOrigin(&http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(page))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if title := origin.HTML["title"]; title != "Escaping &lt;tags&gt;" {
+		t.Errorf("expected title to be unescaped once, got %q", title)
+	}
+}
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Provider is a source of stats for a looked up URL.
+type Provider interface {
+	// Name is used as the key of provider stats in the result.
+	Name() string
+	// BuildRequest prepares request that fetches stats for lookupURL.
+	BuildRequest(lookupURL string) (*http.Request, error)
+	// Parse reads stats from response of request built with BuildRequest.
+	Parse(response *http.Response) (*PlatformResult, error)
+}
+
+// Registry keeps providers that are queried by collector.
+type Registry struct {
+	mu        sync.RWMutex
+	providers []Provider
+}
+
+// DefaultRegistry holds built-in platforms and is used by New.
+var DefaultRegistry = NewRegistry(
+	Facebook(),
+	Pinterest(),
+	Linkedin(),
+	GooglePlus(),
+	Reddit(),
+	Bufferapp(),
+	Stumbleupon(),
+	Pocket(),
+	Tumblr(),
+)
+
+// NewRegistry creates registry with given providers. It panics when
+// providers can't be registered.
+func NewRegistry(providers ...Provider) *Registry {
+	registry := &Registry{}
+	for _, provider := range providers {
+		if err := registry.Register(provider); err != nil {
+			panic(err)
+		}
+	}
+
+	return registry
+}
+
+// Register adds provider to registry. Names must be unique.
+func (r *Registry) Register(provider Provider) error {
+	name := provider.Name()
+	if name == "" {
+		return errors.New("Provider name is required.")
+	}
+
+	if name == "origin" || name == "meta" || name == "errors" || name == "platform_errors" {
+		return fmt.Errorf("Provider name %q is reserved.", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.providers {
+		if existing.Name() == name {
+			return fmt.Errorf("Provider %q is already registered.", name)
+		}
+	}
+
+	r.providers = append(r.providers, provider)
+	return nil
+}
+
+// Unregister removes provider with name and reports if it was registered.
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.providers {
+		if existing.Name() == name {
+			r.providers = append(r.providers[:i:i], r.providers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lookup returns provider registered under name.
+func (r *Registry) Lookup(name string) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, provider := range r.providers {
+		if provider.Name() == name {
+			return provider, true
+		}
+	}
+
+	return nil, false
+}
+
+// Providers returns registered providers in order of registration.
+func (r *Registry) Providers() []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]Provider{}, r.providers...)
+}
+
+// Register adds provider to DefaultRegistry.
+func Register(provider Provider) error {
+	return DefaultRegistry.Register(provider)
+}
+
+// Unregister removes provider with name from DefaultRegistry.
+func Unregister(name string) bool {
+	return DefaultRegistry.Unregister(name)
+}
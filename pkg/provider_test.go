@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"net/http"
+	"testing"
+)
+
+// namedProvider is a provider that only has a name.
+type namedProvider string
+
+func (p namedProvider) Name() string {
+	return string(p)
+}
+
+func (p namedProvider) BuildRequest(lookupURL string) (*http.Request, error) {
+	return http.NewRequest("GET", lookupURL, nil)
+}
+
+func (p namedProvider) Parse(response *http.Response) (*PlatformResult, error) {
+	return &PlatformResult{}, nil
+}
+
+func TestRegistryRegistersProviders(t *testing.T) {
+	registry := NewRegistry(namedProvider("first"))
+	if err := registry.Register(namedProvider("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	providers := registry.Providers()
+	if len(providers) != 2 || providers[0].Name() != "first" || providers[1].Name() != "second" {
+		t.Errorf("expected providers in order of registration, got %v", providers)
+	}
+
+	if provider, ok := registry.Lookup("second"); !ok || provider.Name() != "second" {
+		t.Errorf("expected second to be found, got %v", provider)
+	}
+
+	if _, ok := registry.Lookup("third"); ok {
+		t.Error("expected third not to be found")
+	}
+
+	providers[0] = nil
+	if registry.Providers()[0] == nil {
+		t.Error("expected Providers to return a copy")
+	}
+}
+
+func TestRegistryRejectsInvalidNames(t *testing.T) {
+	registry := NewRegistry(namedProvider("first"))
+	for _, name := range []string{"", "origin", "meta", "errors", "platform_errors", "first"} {
+		if err := registry.Register(namedProvider(name)); err == nil {
+			t.Errorf("expected name %q to be rejected", name)
+		}
+	}
+
+	if len(registry.Providers()) != 1 {
+		t.Errorf("expected rejected providers not to be added, got %v", registry.Providers())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected NewRegistry to panic on duplicate names")
+		}
+	}()
+	NewRegistry(namedProvider("first"), namedProvider("first"))
+}
+
+func TestRegistryUnregistersProviders(t *testing.T) {
+	registry := NewRegistry(namedProvider("first"), namedProvider("second"))
+	if !registry.Unregister("first") {
+		t.Error("expected first to be unregistered")
+	}
+
+	if registry.Unregister("first") {
+		t.Error("expected first not to be registered anymore")
+	}
+
+	if providers := registry.Providers(); len(providers) != 1 || providers[0].Name() != "second" {
+		t.Errorf("expected only second to be left, got %v", providers)
+	}
+
+	if err := registry.Register(namedProvider("first")); err != nil {
+		t.Errorf("expected unregistered name to be available again, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is token bucket of a platform.
+type RateLimit struct {
+	// RPS is the number of requests per second allowed on average. Requests
+	// aren't limited when it is 0.
+	RPS float64
+	// Burst is the number of requests allowed at once. Defaults to 1.
+	Burst int
+	// MaxQueue is the number of requests that may wait for their turn.
+	// Further requests fail right away. Defaults to 10, negative value makes
+	// queue unbounded.
+	MaxQueue int
+}
+
+// DefaultRateLimiter throttles collections whose Options have no
+// RateLimiter, including those of New. It doesn't throttle requests unless
+// it is set.
+var DefaultRateLimiter *RateLimiter
+
+// RateLimiter throttles requests to platforms. Collections that share it
+// share limits, so it is meant to be created once, e.g. per server. Use
+// NewRateLimiter to create it.
+type RateLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]RateLimit
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	queued int
+}
+
+// NewRateLimiter creates limiter with limits of platforms by name. Limit
+// under "*" applies to platforms without their own.
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{limits: limits, buckets: map[string]*bucket{}}
+}
+
+func (l *RateLimiter) limit(platform string) (RateLimit, bool) {
+	limit, ok := l.limits[platform]
+	if !ok {
+		limit, ok = l.limits["*"]
+	}
+
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	if limit.MaxQueue == 0 {
+		limit.MaxQueue = 10
+	}
+
+	return limit, ok && limit.RPS > 0
+}
+
+// Wait blocks until request to platform is allowed and returns how long it
+// waited. Error is PlatformError of kind ErrorRateLimited when queue is
+// full or wait would outlast deadline of ctx, and of kind ErrorTimeout or
+// ErrorCanceled when ctx is done while waiting. Nil limiter allows all
+// requests.
+func (l *RateLimiter) Wait(ctx context.Context, platform string) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+
+	wait, b, err := l.reserve(platform)
+	if err != nil || wait == 0 {
+		return 0, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		l.cancel(b)
+		return 0, &PlatformError{
+			Platform:  platform,
+			Kind:      ErrorRateLimited,
+			Retryable: true,
+			Err:       errors.New("Rate limit of " + platform + " would outlast deadline."),
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.cancel(b)
+		return 0, requestError(platform, ctx.Err(), ErrorTimeout, Options{})
+	case <-timer.C:
+		l.mu.Lock()
+		b.queued--
+		l.mu.Unlock()
+		return wait, nil
+	}
+}
+
+// reserve takes token of platform. It returns wait until the token is
+// available and bucket it was queued in when there is one.
+func (l *RateLimiter) reserve(platform string) (time.Duration, *bucket, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.limit(platform)
+	if !ok {
+		return 0, nil, nil
+	}
+
+	now := time.Now()
+	b, ok := l.buckets[platform]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[platform] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.RPS)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, nil, nil
+	}
+
+	if limit.MaxQueue > 0 && b.queued >= limit.MaxQueue {
+		return 0, nil, &PlatformError{
+			Platform:  platform,
+			Kind:      ErrorRateLimited,
+			Retryable: true,
+			Err:       errors.New("Rate limit queue of " + platform + " is full."),
+		}
+	}
+
+	wait := time.Duration((1 - b.tokens) / limit.RPS * float64(time.Second))
+	b.tokens--
+	b.queued++
+	return wait, b, nil
+}
+
+// cancel returns token of request that gave up waiting.
+func (l *RateLimiter) cancel(b *bucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b.tokens++
+	b.queued--
+}
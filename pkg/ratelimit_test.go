@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaitsForTokens(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"*": {RPS: 20, Burst: 2}})
+
+	for i := 0; i < 2; i++ {
+		if wait, err := limiter.Wait(context.Background(), "facebook"); wait != 0 || err != nil {
+			t.Errorf("expected burst to pass right away, waited %v with %v", wait, err)
+		}
+	}
+
+	if wait, err := limiter.Wait(context.Background(), "facebook"); wait < 30*time.Millisecond || err != nil {
+		t.Errorf("expected request after burst to wait, waited %v with %v", wait, err)
+	}
+
+	if wait, _ := limiter.Wait(context.Background(), "reddit"); wait != 0 {
+		t.Errorf("expected platforms to have own buckets, waited %v", wait)
+	}
+
+	var limiterOff *RateLimiter
+	if wait, err := limiterOff.Wait(context.Background(), "facebook"); wait != 0 || err != nil {
+		t.Errorf("expected nil limiter to allow requests, waited %v with %v", wait, err)
+	}
+}
+
+func TestRateLimiterFailsFast(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"facebook": {RPS: 5, MaxQueue: 1}})
+	limiter.Wait(context.Background(), "facebook")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		limiter.Wait(context.Background(), "facebook")
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	var platformError *PlatformError
+	_, err := limiter.Wait(context.Background(), "facebook")
+	if !errors.As(err, &platformError) || platformError.Kind != ErrorRateLimited {
+		t.Errorf("expected full queue to fail with rate_limited, got %v", err)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = limiter.Wait(ctx, "facebook")
+	if !errors.As(err, &platformError) || platformError.Kind != ErrorRateLimited || time.Since(start) > 20*time.Millisecond {
+		t.Errorf("expected wait outlasting deadline to fail right away, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestRateLimiterBoundsQueueByDefault(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"*": {RPS: 0.01}})
+	limiter.Wait(context.Background(), "facebook")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Wait(ctx, "facebook")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	var platformError *PlatformError
+	_, err := limiter.Wait(context.Background(), "facebook")
+	if !errors.As(err, &platformError) || platformError.Kind != ErrorRateLimited {
+		t.Errorf("expected queue of 10 to be full, got %v", err)
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestCollectReportsQueuedIn(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	limiter := NewRateLimiter(map[string]RateLimit{"counter": {RPS: 20}})
+	opts := Options{Registry: NewRegistry(testPlatform("counter", server.URL+"/count")), RateLimiter: limiter}
+
+	first, _ := Collect(context.Background(), server.URL+"/", opts)
+	second, _ := Collect(context.Background(), server.URL+"/", opts)
+
+	if counter := first.Platforms["counter"]; counter.Error != nil || counter.QueuedIn != 0 {
+		t.Errorf("expected first request not to queue, got %+v", counter)
+	}
+
+	if counter := second.Platforms["counter"]; counter.Error != nil || counter.QueuedIn <= 0 {
+		t.Errorf("expected second request to queue, got %+v", counter)
+	}
+
+	DefaultRateLimiter = NewRateLimiter(map[string]RateLimit{"counter": {RPS: 1}})
+	defer func() { DefaultRateLimiter = nil }()
+
+	opts = Options{Registry: opts.Registry, Timeout: 100 * time.Millisecond}
+	Collect(context.Background(), server.URL+"/", opts)
+	third, _ := Collect(context.Background(), server.URL+"/", opts)
+
+	var platformError *PlatformError
+	if counter := third.Platforms["counter"]; !errors.As(counter.Error, &platformError) || platformError.Kind != ErrorRateLimited {
+		t.Errorf("expected wait beyond deadline of collection to fail right away, got %+v", counter)
+	}
+}
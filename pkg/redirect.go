@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultMaxRedirects is number of redirects followed when Options don't
+// set MaxRedirects, same as net/http does.
+const defaultMaxRedirects = 10
+
+// Redirect is a single hop of redirect chain of looked up URL.
+type Redirect struct {
+	// URL is address that responded with redirect.
+	URL string `json:"url"`
+	// Status is HTTP status code of redirect response.
+	Status int `json:"status"`
+	// Location is Location header of redirect response as sent.
+	Location string `json:"location"`
+	// Latency is time from sending request to receiving redirect response.
+	Latency time.Duration `json:"-"`
+	// CrossDomain is true when redirect leads to another host.
+	CrossDomain bool `json:"cross_domain"`
+	// Downgrade is true when redirect leads from HTTPS to HTTP.
+	Downgrade bool `json:"downgrade"`
+}
+
+type redirectJSON Redirect
+
+// MarshalJSON renders redirect with latency in seconds.
+func (r *Redirect) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*redirectJSON
+		Latency float64 `json:"latency"`
+	}{(*redirectJSON)(r), r.Latency.Seconds()})
+}
+
+// UnmarshalJSON reads redirect rendered by MarshalJSON.
+func (r *Redirect) UnmarshalJSON(body []byte) error {
+	data := struct {
+		*redirectJSON
+		Latency interface{} `json:"latency"`
+	}{redirectJSON: (*redirectJSON)(r)}
+
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	r.Latency = fromSeconds(data.Latency)
+	return nil
+}
+
+// newRedirect describes hop from previous request to next one, which
+// net/http built from redirect response of previous.
+func newRedirect(previous *http.Request, next *http.Request, latency time.Duration) *Redirect {
+	redirect := &Redirect{
+		URL:         previous.URL.String(),
+		Latency:     latency,
+		CrossDomain: !sameSite(previous.URL, next.URL),
+		Downgrade:   previous.URL.Scheme == "https" && next.URL.Scheme == "http",
+	}
+
+	if next.Response != nil {
+		redirect.Status = next.Response.StatusCode
+		redirect.Location = next.Response.Header.Get("Location")
+	}
+
+	return redirect
+}
+
+// sameSite compares hosts of URLs, ignoring case and "www." prefix.
+func sameSite(a *url.URL, b *url.URL) bool {
+	host := func(u *url.URL) string {
+		return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	}
+
+	return host(a) == host(b)
+}
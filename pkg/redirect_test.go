@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newRedirectServer() *httptest.Server {
+	handler := testHandler()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/short":
+			http.Redirect(w, r, "/track", http.StatusMovedPermanently)
+		case "/track":
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop-back", http.StatusFound)
+		case "/loop-back":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+
+	return server
+}
+
+func TestCollectReportsRedirects(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
+
+	result, _ := Collect(context.Background(), server.URL+"/short", Options{Registry: NewRegistry()})
+	if len(result.Errors) != 0 || result.Origin == nil {
+		t.Fatalf("unexpected errors %v", result.Errors)
+	}
+
+	redirects := result.Origin.Redirects
+	if len(redirects) != 2 || result.Origin.Status != http.StatusOK {
+		t.Fatalf("expected 2 redirects, got %+v", redirects)
+	}
+
+	if r := redirects[0]; r.URL != server.URL+"/short" || r.Status != 301 || r.Location != "/track" || r.CrossDomain {
+		t.Errorf("unexpected first hop %+v", r)
+	}
+
+	if r := redirects[1]; r.Status != 302 || !r.CrossDomain || r.Downgrade || r.Latency <= 0 {
+		t.Errorf("unexpected second hop %+v", r)
+	}
+
+	body, _ := json.Marshal(result.Origin)
+	origin := &Origin{}
+	if err := json.Unmarshal(body, origin); err != nil || len(origin.Redirects) != 2 || origin.Redirects[1].Latency <= 0 {
+		t.Errorf("expected redirects to survive JSON, got %s", body)
+	}
+}
+
+func TestCollectStopsRedirects(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
+
+	result, _ := Collect(context.Background(), server.URL+"/loop", Options{Registry: NewRegistry()})
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Error(), "Redirect loop") {
+		t.Errorf("expected loop to be detected, got %v", result.Errors)
+	}
+
+	if result.Origin == nil || len(result.Origin.Redirects) != 2 || result.Origin.Status != http.StatusFound {
+		t.Errorf("expected redirect chain of loop, got %+v", result.Origin)
+	}
+
+	result, _ = Collect(context.Background(), server.URL+"/short", Options{Registry: NewRegistry(), MaxRedirects: 1})
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Error(), "Stopped after 1 redirects") {
+		t.Errorf("expected redirects to be limited, got %v", result.Errors)
+	}
+
+	if result.Meta.LookupURL != server.URL+"/track" {
+		t.Errorf("expected last followed URL to be looked up, got %s", result.Meta.LookupURL)
+	}
+}
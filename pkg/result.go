@@ -0,0 +1,402 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// PlatformResult holds stats collected from a single platform.
+type PlatformResult struct {
+	Name        string
+	Count       int64
+	FetchedIn   time.Duration
+	CompletedIn time.Duration
+	Fields      map[string]interface{}
+	// Variants holds counts of each URL variant when variants are queried.
+	Variants map[string]int64
+	// Attempts is the number of requests made, more than one when retried.
+	Attempts int
+	// QueuedIn is time spent waiting for rate limit of platform.
+	QueuedIn time.Duration
+	Error    error
+}
+
+// Meta holds values aggregated over all platforms.
+type Meta struct {
+	Total int64 `json:"total"`
+	// LookupURL is URL that platforms were queried for.
+	LookupURL string `json:"lookup_url,omitempty"`
+	// Variants are all URLs queried when variants are enabled.
+	Variants []string `json:"variants,omitempty"`
+}
+
+// Origin holds data about the looked up page itself.
+type Origin struct {
+	Type             string
+	URL              string
+	Title            string
+	Description      string
+	Determiner       string
+	SiteName         string
+	Locale           string
+	LocalesAlternate []string
+	Images           []*Image
+	Videos           []*Video
+	Audios           []*Audio
+	Article          *Article
+	Book             *Book
+	Profile          *Profile
+	// Canonical is URL of <link rel="canonical"> of page.
+	Canonical string
+	// Twitter holds twitter:* properties without prefix.
+	Twitter map[string]string
+	// HTML holds <title> and standard <meta> tags such as description.
+	HTML map[string]string
+	// JSONLD holds schema.org items of JSON-LD scripts.
+	JSONLD []map[string]interface{}
+	// Metadata combines properties of all sources.
+	Metadata map[string]*Metadata
+	// Status is HTTP status code of the last response.
+	Status int
+	// Redirects are hops followed from looked up URL to page.
+	Redirects   []*Redirect
+	URLs        []string
+	FetchedIn   time.Duration
+	CompletedIn time.Duration
+}
+
+// Result is the outcome of collecting stats for one URL.
+type Result struct {
+	URL       string
+	Platforms map[string]*PlatformResult
+	Origin    *Origin
+	Meta      Meta
+	Errors    []error
+}
+
+func newResult(lookupURL string) *Result {
+	return &Result{
+		URL:       lookupURL,
+		Platforms: map[string]*PlatformResult{},
+		Errors:    []error{},
+	}
+}
+
+// MarshalJSON renders platform stats together with timings in seconds.
+func (p *PlatformResult) MarshalJSON() ([]byte, error) {
+	data := map[string]interface{}{}
+	for k, v := range p.Fields {
+		data[k] = v
+	}
+
+	if len(p.Variants) > 0 {
+		data["variants"] = p.Variants
+	}
+
+	if p.Attempts > 0 {
+		data["attempts"] = p.Attempts
+	}
+
+	if p.QueuedIn > 0 {
+		data["queued_in"] = p.QueuedIn.Seconds()
+	}
+
+	data["count"] = p.Count
+	data["fetched_in"] = p.FetchedIn.Seconds()
+	data["completed_in"] = p.CompletedIn.Seconds()
+	return json.Marshal(data)
+}
+
+// MarshalJSON renders only non empty OpenGraph fields, as /stats always did.
+func (o *Origin) MarshalJSON() ([]byte, error) {
+	data := map[string]interface{}{}
+	fields := map[string]string{
+		"Type":        o.Type,
+		"URL":         o.URL,
+		"Title":       o.Title,
+		"Description": o.Description,
+		"Determiner":  o.Determiner,
+		"SiteName":    o.SiteName,
+		"Locale":      o.Locale,
+	}
+
+	for k, v := range fields {
+		if v != "" {
+			data[k] = v
+		}
+	}
+
+	if o.Canonical != "" {
+		data["canonical"] = o.Canonical
+	}
+
+	if len(o.LocalesAlternate) > 0 {
+		data["LocalesAlternate"] = o.LocalesAlternate
+	}
+
+	if len(o.Images) > 0 {
+		data["Images"] = o.Images
+	}
+
+	if len(o.Videos) > 0 {
+		data["Videos"] = o.Videos
+	}
+
+	if len(o.Audios) > 0 {
+		data["Audios"] = o.Audios
+	}
+
+	if o.Article != nil {
+		data["Article"] = o.Article
+	}
+
+	if o.Book != nil {
+		data["Book"] = o.Book
+	}
+
+	if o.Profile != nil {
+		data["Profile"] = o.Profile
+	}
+
+	if len(o.Twitter) > 0 {
+		data["twitter"] = o.Twitter
+	}
+
+	if len(o.HTML) > 0 {
+		data["html"] = o.HTML
+	}
+
+	if len(o.JSONLD) > 0 {
+		data["json_ld"] = o.JSONLD
+	}
+
+	if len(o.Metadata) > 0 {
+		data["metadata"] = o.Metadata
+	}
+
+	if o.Status != 0 {
+		data["status"] = o.Status
+	}
+
+	data["redirects"] = o.Redirects
+	data["urls"] = o.URLs
+	data["fetched_in"] = o.FetchedIn.Seconds()
+	data["completed_in"] = o.CompletedIn.Seconds()
+	return json.Marshal(data)
+}
+
+// MarshalJSON flattens result into the /stats shape where platforms,
+// "origin", "meta" and "errors" are sibling keys. "platform_errors" describes
+// errors of platforms as objects.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	data := map[string]interface{}{}
+	for name, platform := range r.Platforms {
+		if platform.Error == nil {
+			data[name] = platform
+		}
+	}
+
+	if r.Origin != nil {
+		data["origin"] = r.Origin
+	}
+
+	errorsStrings := []string{}
+	platformErrors := []*PlatformError{}
+	for _, error := range r.Errors {
+		errorsStrings = append(errorsStrings, error.Error())
+
+		var platformError *PlatformError
+		if errors.As(error, &platformError) {
+			platformErrors = append(platformErrors, platformError)
+		}
+	}
+
+	data["meta"] = r.Meta
+	data["errors"] = errorsStrings
+	data["platform_errors"] = platformErrors
+	return json.Marshal(data)
+}
+
+// UnmarshalJSON reads platform stats rendered by MarshalJSON.
+func (p *PlatformResult) UnmarshalJSON(body []byte) error {
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	p.Count = toCount(data["count"])
+	p.FetchedIn = fromSeconds(data["fetched_in"])
+	p.CompletedIn = fromSeconds(data["completed_in"])
+	p.Attempts = int(toCount(data["attempts"]))
+	p.QueuedIn = fromSeconds(data["queued_in"])
+	p.Fields = map[string]interface{}{}
+	for k, v := range data {
+		if k != "count" && k != "fetched_in" && k != "completed_in" && k != "variants" && k != "attempts" &&
+			k != "queued_in" {
+			p.Fields[k] = v
+		}
+	}
+
+	if variants, ok := data["variants"].(map[string]interface{}); ok {
+		p.Variants = map[string]int64{}
+		for variant, count := range variants {
+			p.Variants[variant] = toCount(count)
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalJSON reads origin rendered by MarshalJSON.
+func (o *Origin) UnmarshalJSON(body []byte) error {
+	var data struct {
+		Type             string
+		URL              string
+		Title            string
+		Description      string
+		Determiner       string
+		SiteName         string
+		Locale           string
+		Canonical        string `json:"canonical"`
+		LocalesAlternate []string
+		Images           []*Image
+		Videos           []*Video
+		Audios           []*Audio
+		Article          *Article
+		Book             *Book
+		Profile          *Profile
+		Twitter          map[string]string        `json:"twitter"`
+		HTML             map[string]string        `json:"html"`
+		JSONLD           []map[string]interface{} `json:"json_ld"`
+		Metadata         map[string]*Metadata     `json:"metadata"`
+		Status           int                      `json:"status"`
+		Redirects        []*Redirect              `json:"redirects"`
+		URLs             []string                 `json:"urls"`
+		FetchedIn        interface{}              `json:"fetched_in"`
+		CompletedIn      interface{}              `json:"completed_in"`
+	}
+
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	*o = Origin{
+		Type:             data.Type,
+		URL:              data.URL,
+		Title:            data.Title,
+		Description:      data.Description,
+		Determiner:       data.Determiner,
+		SiteName:         data.SiteName,
+		Locale:           data.Locale,
+		Canonical:        data.Canonical,
+		LocalesAlternate: data.LocalesAlternate,
+		Images:           data.Images,
+		Videos:           data.Videos,
+		Audios:           data.Audios,
+		Article:          data.Article,
+		Book:             data.Book,
+		Profile:          data.Profile,
+		Twitter:          data.Twitter,
+		HTML:             data.HTML,
+		JSONLD:           data.JSONLD,
+		Metadata:         data.Metadata,
+		Status:           data.Status,
+		Redirects:        data.Redirects,
+		URLs:             data.URLs,
+		FetchedIn:        fromSeconds(data.FetchedIn),
+		CompletedIn:      fromSeconds(data.CompletedIn),
+	}
+
+	return nil
+}
+
+// UnmarshalJSON reads result rendered by MarshalJSON. Errors of platforms are
+// restored as PlatformError wrapping only message, other errors as messages
+// and URL is left empty.
+func (r *Result) UnmarshalJSON(body []byte) error {
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	*r = *newResult("")
+	var messages []string
+	platformErrors := []*PlatformError{}
+	for name, raw := range data {
+		switch name {
+		case "meta":
+			if err := json.Unmarshal(raw, &r.Meta); err != nil {
+				return err
+			}
+		case "errors":
+			if err := json.Unmarshal(raw, &messages); err != nil {
+				return err
+			}
+		case "platform_errors":
+			if err := json.Unmarshal(raw, &platformErrors); err != nil {
+				return err
+			}
+		case "origin":
+			r.Origin = &Origin{}
+			if err := json.Unmarshal(raw, r.Origin); err != nil {
+				return err
+			}
+		default:
+			platform := &PlatformResult{Name: name}
+			if err := json.Unmarshal(raw, platform); err != nil {
+				return err
+			}
+
+			r.Platforms[name] = platform
+		}
+	}
+
+	// Platform errors are in the same order as messages they were rendered
+	// among.
+	next := 0
+	for _, message := range messages {
+		if next < len(platformErrors) && platformErrors[next].Error() == message {
+			platformError := platformErrors[next]
+			next++
+
+			r.Errors = append(r.Errors, platformError)
+			if _, ok := r.Platforms[platformError.Platform]; !ok && platformError.Platform != "origin" {
+				r.Platforms[platformError.Platform] = &PlatformResult{Name: platformError.Platform, Error: platformError}
+			}
+			continue
+		}
+
+		r.Errors = append(r.Errors, errors.New(message))
+	}
+
+	return nil
+}
+
+func fromSeconds(value interface{}) time.Duration {
+	seconds, _ := value.(float64)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func toCount(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(math.Floor(v + 0.5))
+	case float32:
+		return int64(math.Floor(float64(v) + 0.5))
+	case json.Number:
+		n, _ := v.Float64()
+		return toCount(n)
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return toCount(n)
+	}
+
+	return 0
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy describes how requests to a platform are retried. Zero value
+// makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts int
+	// Backoff is wait before the second attempt, doubled for every next
+	// one. Defaults to 200 milliseconds.
+	Backoff time.Duration
+	// MaxBackoff caps wait between attempts. Platform asking to wait longer
+	// with Retry-After header isn't retried. Defaults to 5 seconds.
+	MaxBackoff time.Duration
+	// Jitter spreads waits randomly by up to this fraction. Defaults to 0.2,
+	// negative value disables it.
+	Jitter float64
+	// RetryOn lists HTTP statuses that are retried, either codes such as
+	// "429" or classes such as "5xx". Defaults to 429 and 5xx. Timeouts and
+	// network errors are always retried.
+	RetryOn []string
+}
+
+// retryPolicy returns policy of platform with name.
+func (opts Options) retryPolicy(name string) RetryPolicy {
+	if policy, ok := opts.PlatformRetry[name]; ok {
+		return policy
+	}
+
+	return opts.Retry
+}
+
+// retries returns whether attempt that failed with err should be followed
+// by another one and how long to wait before it.
+func (policy RetryPolicy) retries(attempt int, err error) (time.Duration, bool) {
+	var platformError *PlatformError
+	if attempt >= policy.MaxAttempts || !errors.As(err, &platformError) {
+		return 0, false
+	}
+
+	switch platformError.Kind {
+	case ErrorHTTPStatus:
+		if !policy.retriesStatus(platformError.StatusCode) {
+			return 0, false
+		}
+	case ErrorTimeout, ErrorNetwork:
+	default:
+		return 0, false
+	}
+
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 5 * time.Second
+	}
+
+	wait := policy.Backoff
+	if wait <= 0 {
+		wait = 200 * time.Millisecond
+	}
+
+	for i := 1; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	jitter := policy.Jitter
+	if jitter == 0 {
+		jitter = 0.2
+	}
+
+	if jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * jitter * float64(wait))
+	}
+
+	if platformError.retryAfter > maxBackoff {
+		return 0, false
+	}
+
+	if platformError.retryAfter > wait {
+		wait = platformError.retryAfter
+	}
+
+	return wait, true
+}
+
+func (policy RetryPolicy) retriesStatus(statusCode int) bool {
+	retryOn := policy.RetryOn
+	if retryOn == nil {
+		retryOn = []string{"429", "5xx"}
+	}
+
+	code := strconv.Itoa(statusCode)
+	for _, status := range retryOn {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if status == code || (strings.HasSuffix(status, "xx") && len(status) == 3 && status[0] == code[0]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRetryAfter reads Retry-After header given either as seconds or as
+// HTTP date.
+func parseRetryAfter(response *http.Response) time.Duration {
+	value := strings.TrimSpace(response.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil && at.After(time.Now()) {
+		return time.Until(at)
+	}
+
+	return 0
+}
+
+// waitRetry waits before the next attempt. It returns false without waiting
+// when the wait would outlast deadline of ctx, or when ctx is done first.
+func waitRetry(ctx context.Context, wait time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFlakyServer(failures int64, retryAfter string) (*httptest.Server, *int64) {
+	var requests int64
+	handler := testHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" {
+			if atomic.AddInt64(&requests, 1) <= failures {
+				w.Header().Set("Retry-After", retryAfter)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			r.URL.Path = "/count"
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	return server, &requests
+}
+
+func TestCollectRetriesTransientFailures(t *testing.T) {
+	server, requests := newFlakyServer(2, "0")
+	defer server.Close()
+
+	registry := NewRegistry(testPlatform("flaky", server.URL+"/flaky"), testPlatform("missing", server.URL+"/missing"))
+	result, _ := Collect(context.Background(), server.URL+"/", Options{
+		Registry: registry,
+		Retry:    RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	})
+
+	if flaky := result.Platforms["flaky"]; flaky.Error != nil || flaky.Count != 3 || flaky.Attempts != 3 {
+		t.Errorf("expected flaky platform to succeed on third attempt, got %+v", flaky)
+	}
+
+	if missing := result.Platforms["missing"]; missing.Error == nil || missing.Attempts != 1 {
+		t.Errorf("expected 404 not to be retried, got %+v", missing)
+	}
+
+	if atomic.LoadInt64(requests) != 3 {
+		t.Errorf("expected 3 requests, got %d", atomic.LoadInt64(requests))
+	}
+}
+
+func TestCollectRetriesWithinLimits(t *testing.T) {
+	server, _ := newFlakyServer(10, "60")
+	defer server.Close()
+
+	registry := NewRegistry(testPlatform("flaky", server.URL+"/flaky"))
+	result, _ := Collect(context.Background(), server.URL+"/", Options{
+		Registry: registry,
+		Retry:    RetryPolicy{MaxAttempts: 5, Backoff: time.Millisecond},
+	})
+
+	if flaky := result.Platforms["flaky"]; flaky.Attempts != 1 {
+		t.Errorf("expected long Retry-After to stop retries, got %d attempts", flaky.Attempts)
+	}
+
+	server, requests := newFlakyServer(10, "")
+	defer server.Close()
+
+	registry = NewRegistry(testPlatform("flaky", server.URL+"/flaky"))
+	result, _ = Collect(context.Background(), server.URL+"/", Options{
+		Registry:      registry,
+		Deadline:      300 * time.Millisecond,
+		Retry:         RetryPolicy{MaxAttempts: 5, Backoff: time.Millisecond},
+		PlatformRetry: map[string]RetryPolicy{"flaky": {MaxAttempts: 5, Backoff: time.Second, Jitter: -1}},
+	})
+
+	if flaky := result.Platforms["flaky"]; flaky.Attempts != 1 || atomic.LoadInt64(requests) != 1 {
+		t.Errorf("expected backoff beyond deadline to stop retries, got %d attempts", flaky.Attempts)
+	}
+
+	result, _ = Collect(context.Background(), server.URL+"/", Options{
+		Registry: registry,
+		Timeout:  100 * time.Millisecond,
+		Retry:    RetryPolicy{MaxAttempts: 10, Backoff: 100 * time.Millisecond, MaxBackoff: 100 * time.Millisecond, Jitter: -1},
+	})
+
+	if flaky := result.Platforms["flaky"]; flaky.Attempts < 1 || flaky.Attempts >= 10 {
+		t.Errorf("expected default deadline to bound retries, got %d attempts", flaky.Attempts)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestCollectPropagatesDefaultDeadline(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	var mu sync.Mutex
+	remaining := map[string]time.Duration{}
+	client := &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		deadline, ok := request.Context().Deadline()
+		mu.Lock()
+		if ok {
+			remaining[request.URL.Path] = time.Until(deadline)
+		} else {
+			remaining[request.URL.Path] = -1
+		}
+		mu.Unlock()
+
+		return http.DefaultTransport.RoundTrip(request)
+	})}
+
+	registry := NewRegistry(testPlatform("ok", server.URL+"/count"))
+	Collect(context.Background(), server.URL+"/", Options{Registry: registry, HTTPClient: client})
+
+	if len(remaining) != 2 {
+		t.Fatalf("expected origin and platform requests, got %v", remaining)
+	}
+
+	for path, left := range remaining {
+		if left <= 0 || left > 3*globalTimeout {
+			t.Errorf("expected request to %s to carry default deadline, got %v left", path, left)
+		}
+	}
+}
+
+func TestRetryPolicyStatuses(t *testing.T) {
+	policy := RetryPolicy{RetryOn: []string{"502", "4xx"}}
+	for code, expected := range map[int]bool{502: true, 503: false, 404: true, 429: true, 200: false} {
+		if policy.retriesStatus(code) != expected {
+			t.Errorf("expected retry of %d to be %v", code, expected)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"context"
+)
+
+// Event reports progress of collection started with Stream. Exactly one of
+// its fields other than Err is set.
+type Event struct {
+	// Origin is set once looked up page is resolved.
+	Origin *Origin
+	// Platform is set whenever a platform completes, successfully or not.
+	Platform *PlatformResult
+	// Result is set on the last event and holds all stats aggregated.
+	Result *Result
+	// Err is set on the last event when ctx was done before collection
+	// completed.
+	Err error
+}
+
+// Stream collects stats as Collect does, but emits results of platforms as
+// soon as they complete, followed by event with aggregated result. Channel
+// is closed after the last event. Events that can't be delivered before ctx
+// is done are dropped.
+func Stream(ctx context.Context, lookupURL string, opts Options) <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+		emit := func(event Event) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}
+
+		result, err := collect(ctx, lookupURL, opts, emit)
+		emit(Event{Result: result, Err: err})
+	}()
+
+	return events
+}
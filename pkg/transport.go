@@ -0,0 +1,201 @@
+package collector
+
+import (
+	"container/list"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transports keeps long lived transports, one per proxy and TLS setting, so
+// connections and TLS sessions are reused between collections.
+type Transports struct {
+	// MaxIdleConns limits idle connections kept over all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits idle connections kept per host.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes connections that were idle for that long.
+	IdleConnTimeout time.Duration
+	// MaxTransports limits pooled transports. Idle connections of least
+	// recently used transport are closed when it is dropped. Defaults to 64.
+	MaxTransports int
+
+	mu          sync.Mutex
+	order       *list.List
+	transports  map[transportKey]*list.Element
+	requests    int64
+	newConns    int64
+	reusedConns int64
+}
+
+// TransportStats describes usage of pooled transports.
+type TransportStats struct {
+	Transports        int   `json:"transports"`
+	Requests          int64 `json:"requests"`
+	NewConnections    int64 `json:"new_connections"`
+	ReusedConnections int64 `json:"reused_connections"`
+}
+
+type transportKey struct {
+	proxy    string
+	insecure bool
+	caFile   string
+}
+
+type pooledEntry struct {
+	key       transportKey
+	transport *http.Transport
+}
+
+// DefaultTransports is used by collections that don't set own transports.
+var DefaultTransports = NewTransports()
+
+// NewTransports creates empty transport pool with default limits.
+func NewTransports() *Transports {
+	return &Transports{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		MaxTransports:       64,
+		order:               list.New(),
+		transports:          map[transportKey]*list.Element{},
+	}
+}
+
+// RoundTripper returns pooled transport for proxy and TLS settings of opts,
+// creating it on first use and dropping least recently used transports
+// beyond MaxTransports.
+func (t *Transports) RoundTripper(opts Options) (http.RoundTripper, error) {
+	key := transportKey{proxy: opts.Proxy, insecure: opts.InsecureSkipVerify, caFile: opts.CAFile}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if element, ok := t.transports[key]; ok {
+		t.order.MoveToFront(element)
+		return &pooledTransport{Transport: element.Value.(*pooledEntry).transport, pool: t}, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CAFile != "" {
+		roots, error := loadCertPool(opts.CAFile)
+		if error != nil {
+			return nil, error
+		}
+
+		tlsConfig.RootCAs = roots
+	}
+
+	transport := &http.Transport{
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        t.MaxIdleConns,
+		MaxIdleConnsPerHost: t.MaxIdleConnsPerHost,
+		IdleConnTimeout:     t.IdleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+
+	if opts.Proxy != "" {
+		proxyThing, error := url.Parse(opts.Proxy)
+		if error != nil {
+			return nil, error
+		}
+
+		transport.Proxy = http.ProxyURL(proxyThing)
+	}
+
+	t.transports[key] = t.order.PushFront(&pooledEntry{key: key, transport: transport})
+
+	maxTransports := t.MaxTransports
+	if maxTransports <= 0 {
+		maxTransports = 64
+	}
+
+	for t.order.Len() > maxTransports {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+
+		entry := oldest.Value.(*pooledEntry)
+		delete(t.transports, entry.key)
+		// Requests in flight keep using dropped transport until they finish.
+		entry.transport.CloseIdleConnections()
+	}
+
+	return &pooledTransport{Transport: transport, pool: t}, nil
+}
+
+// loadCertPool returns system roots extended with certificates from PEM
+// encoded caFile.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, error := ioutil.ReadFile(caFile)
+	if error != nil {
+		return nil, error
+	}
+
+	roots, error := x509.SystemCertPool()
+	if error != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s.", caFile)
+	}
+
+	return roots, nil
+}
+
+// CloseIdleConnections closes idle connections of all pooled transports.
+func (t *Transports) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, element := range t.transports {
+		element.Value.(*pooledEntry).transport.CloseIdleConnections()
+	}
+}
+
+// Stats returns usage counters of pool.
+func (t *Transports) Stats() TransportStats {
+	t.mu.Lock()
+	count := len(t.transports)
+	t.mu.Unlock()
+
+	return TransportStats{
+		Transports:        count,
+		Requests:          atomic.LoadInt64(&t.requests),
+		NewConnections:    atomic.LoadInt64(&t.newConns),
+		ReusedConnections: atomic.LoadInt64(&t.reusedConns),
+	}
+}
+
+// pooledTransport counts requests and connections of pooled transport.
+type pooledTransport struct {
+	*http.Transport
+	pool *Transports
+}
+
+func (p *pooledTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&p.pool.requests, 1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&p.pool.reusedConns, 1)
+			} else {
+				atomic.AddInt64(&p.pool.newConns, 1)
+			}
+		},
+	}
+
+	ctx := httptrace.WithClientTrace(request.Context(), trace)
+	return p.Transport.RoundTrip(request.WithContext(ctx))
+}
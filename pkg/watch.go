@@ -0,0 +1,440 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Watch is URL collected repeatedly by Watcher.
+type Watch struct {
+	URL string
+	// Interval is time between collections of URL.
+	Interval time.Duration
+	// Platforms limits collection to platforms with given names.
+	Platforms []string
+	// LastRun is time of the last collection.
+	LastRun time.Time
+	// NextRun is time when URL is collected next.
+	NextRun time.Time
+	// Failures counts consecutive failed collections.
+	Failures int
+	// LastResult is result of the last collection.
+	LastResult *Result
+	// LastError is message of error of the last failed collection.
+	LastError string
+
+	running bool
+}
+
+type watchJSON struct {
+	URL        string    `json:"url"`
+	Interval   string    `json:"interval"`
+	Platforms  []string  `json:"platforms,omitempty"`
+	LastRun    time.Time `json:"last_run"`
+	NextRun    time.Time `json:"next_run"`
+	Failures   int       `json:"failures"`
+	LastResult *Result   `json:"last_result,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// MarshalJSON renders watch with interval as duration such as "1h0m0s".
+func (w *Watch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(watchJSON{
+		URL:        w.URL,
+		Interval:   w.Interval.String(),
+		Platforms:  w.Platforms,
+		LastRun:    w.LastRun,
+		NextRun:    w.NextRun,
+		Failures:   w.Failures,
+		LastResult: w.LastResult,
+		LastError:  w.LastError,
+	})
+}
+
+// UnmarshalJSON reads watch rendered by MarshalJSON.
+func (w *Watch) UnmarshalJSON(body []byte) error {
+	var data watchJSON
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	interval, err := time.ParseDuration(data.Interval)
+	if err != nil {
+		return err
+	}
+
+	if data.LastResult != nil {
+		data.LastResult.URL = data.URL
+	}
+
+	*w = Watch{
+		URL:        data.URL,
+		Interval:   interval,
+		Platforms:  data.Platforms,
+		LastRun:    data.LastRun,
+		NextRun:    data.NextRun,
+		Failures:   data.Failures,
+		LastResult: data.LastResult,
+		LastError:  data.LastError,
+	}
+
+	return nil
+}
+
+// Watcher collects watched URLs on their intervals and keeps watchlist in
+// a file, so it survives restarts. Use NewWatcher to create it.
+type Watcher struct {
+	// Options are used for every collection, with Platforms of watch.
+	Options Options
+	// Collect collects stats of watched URL. Defaults to Collect.
+	Collect func(ctx context.Context, lookupURL string, opts Options) (*Result, error)
+	// OnResult is called after every collection that didn't fail.
+	OnResult func(watch *Watch, result *Result)
+	// Jitter spreads runs randomly by up to this fraction of interval, so
+	// URLs added together aren't collected together. Defaults to 0.1,
+	// negative value disables it.
+	Jitter float64
+	// MaxBackoff caps time between runs of URL that keeps failing, which
+	// doubles with every failure. Defaults to 24 hours.
+	MaxBackoff time.Duration
+	// Workers limits number of URLs collected at once. Defaults to 4.
+	Workers int
+
+	path    string
+	mu      sync.Mutex
+	watches map[string]*Watch
+	wake    chan struct{}
+}
+
+// NewWatcher creates watcher that keeps watchlist in file at path, loading
+// watches stored there before. Watchlist is kept only in memory when path is
+// empty.
+func NewWatcher(path string) (*Watcher, error) {
+	w := &Watcher{
+		path:    path,
+		watches: map[string]*Watch{},
+		wake:    make(chan struct{}, 1),
+	}
+
+	if path == "" {
+		return w, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	watches := []*Watch{}
+	if err := json.Unmarshal(body, &watches); err != nil {
+		return nil, err
+	}
+
+	for _, watch := range watches {
+		w.watches[watch.URL] = watch
+	}
+
+	return w, nil
+}
+
+// Add watches lookupURL, collecting it every interval starting right away.
+// Watch of the same URL is updated, keeping its last result.
+func (w *Watcher) Add(lookupURL string, interval time.Duration, platforms []string) (*Watch, error) {
+	if lookupURL == "" {
+		return nil, errors.New("Missing required URL.")
+	}
+
+	if interval <= 0 {
+		return nil, errors.New("Interval must be positive.")
+	}
+
+	w.mu.Lock()
+	watch, ok := w.watches[lookupURL]
+	if !ok {
+		watch = &Watch{URL: lookupURL}
+		w.watches[lookupURL] = watch
+	}
+
+	watch.Interval = interval
+	watch.Platforms = platforms
+	watch.NextRun = time.Now()
+	watch.Failures = 0
+	copied := *watch
+	err := w.save()
+	w.mu.Unlock()
+
+	w.notify()
+	return &copied, err
+}
+
+// Remove stops watching lookupURL. It returns false when URL wasn't watched.
+func (w *Watcher) Remove(lookupURL string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.watches[lookupURL]; !ok {
+		return false, nil
+	}
+
+	delete(w.watches, lookupURL)
+	return true, w.save()
+}
+
+// Watches returns copies of all watches ordered by URL.
+func (w *Watcher) Watches() []*Watch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watches := []*Watch{}
+	for _, watch := range w.watches {
+		copied := *watch
+		watches = append(watches, &copied)
+	}
+
+	sort.Slice(watches, func(i, j int) bool { return watches[i].URL < watches[j].URL })
+	return watches
+}
+
+// Run collects watched URLs when they are due until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	workers := w.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		for _, watch := range w.due(time.Now()) {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				w.release(watch)
+				continue
+			}
+
+			wg.Add(1)
+			go func(watch *Watch) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				w.run(ctx, watch)
+			}(watch)
+		}
+
+		timer := time.NewTimer(w.untilNext(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-w.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// due marks watches that should run at now as running and returns them.
+func (w *Watcher) due(now time.Time) []*Watch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watches := []*Watch{}
+	for _, watch := range w.watches {
+		if !watch.running && !watch.NextRun.After(now) {
+			watch.running = true
+			watches = append(watches, watch)
+		}
+	}
+
+	return watches
+}
+
+func (w *Watcher) release(watch *Watch) {
+	w.mu.Lock()
+	watch.running = false
+	w.mu.Unlock()
+}
+
+// untilNext returns time until the next watch is due, at most a minute.
+func (w *Watcher) untilNext(now time.Time) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wait := time.Minute
+	for _, watch := range w.watches {
+		if until := watch.NextRun.Sub(now); !watch.running && until < wait {
+			wait = until
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait
+}
+
+func (w *Watcher) run(ctx context.Context, watch *Watch) {
+	w.mu.Lock()
+	opts := w.Options
+	opts.Platforms = watch.Platforms
+	lookupURL := watch.URL
+	scheduled := watch.NextRun
+	w.mu.Unlock()
+
+	collect := w.Collect
+	if collect == nil {
+		collect = Collect
+	}
+
+	result, err := collect(ctx, lookupURL, opts)
+	if err == nil && failedResult(result) {
+		err = result.Errors[0]
+	}
+
+	if ctx.Err() != nil {
+		w.release(watch)
+		return
+	}
+
+	w.mu.Lock()
+	watch.running = false
+	if w.watches[lookupURL] != watch {
+		// Watch was removed while it was running.
+		w.mu.Unlock()
+		return
+	}
+
+	watch.LastRun = time.Now()
+	if err != nil {
+		watch.Failures++
+		watch.LastError = err.Error()
+		Logger.Warn("Collecting watched URL failed", "url", lookupURL, "failures", watch.Failures, "error", err)
+	} else {
+		watch.Failures = 0
+		watch.LastError = ""
+		watch.LastResult = result
+	}
+
+	// Watch added again while it was running keeps running right away.
+	if watch.NextRun.Equal(scheduled) {
+		watch.NextRun = watch.LastRun.Add(w.nextInterval(watch))
+	}
+	copied := *watch
+	if err := w.save(); err != nil {
+		Logger.Error("Saving watchlist failed", "error", err)
+	}
+	w.mu.Unlock()
+
+	// Run reschedules after watch is no longer running.
+	w.notify()
+
+	if err == nil && w.OnResult != nil {
+		w.OnResult(&copied, result)
+	}
+}
+
+// nextInterval returns interval of watch, doubled for every consecutive
+// failure up to MaxBackoff and spread by Jitter.
+func (w *Watcher) nextInterval(watch *Watch) time.Duration {
+	maxBackoff := w.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 24 * time.Hour
+	}
+
+	interval := watch.Interval
+	for i := 0; i < watch.Failures && interval < maxBackoff; i++ {
+		interval *= 2
+	}
+
+	if watch.Failures > 0 && interval > maxBackoff {
+		interval = maxBackoff
+	}
+
+	jitter := w.Jitter
+	if jitter == 0 {
+		jitter = 0.1
+	}
+
+	if jitter > 0 {
+		interval += time.Duration((rand.Float64()*2 - 1) * jitter * float64(interval))
+	}
+
+	return interval
+}
+
+// failedResult is true when no platform succeeded and there were errors.
+func failedResult(result *Result) bool {
+	if result == nil || len(result.Errors) == 0 {
+		return false
+	}
+
+	for _, platform := range result.Platforms {
+		if platform.Error == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save writes watchlist to file. It is called with mu held.
+func (w *Watcher) save() error {
+	if w.path == "" {
+		return nil
+	}
+
+	watches := []*Watch{}
+	for _, watch := range w.watches {
+		watches = append(watches, watch)
+	}
+
+	sort.Slice(watches, func(i, j int) bool { return watches[i].URL < watches[j].URL })
+	body, err := json.MarshalIndent(watches, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(w.path), "watchlist")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), w.path)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
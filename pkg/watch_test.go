@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatcherCollectsAndPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socol-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "watchlist.json")
+	watcher, err := NewWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs, results int64
+	watcher.Jitter = -1
+	watcher.Collect = func(ctx context.Context, lookupURL string, opts Options) (*Result, error) {
+		atomic.AddInt64(&runs, 1)
+		result := newResult(lookupURL)
+		result.Meta.Total = 7
+		return result, nil
+	}
+	watcher.OnResult = func(watch *Watch, result *Result) {
+		atomic.AddInt64(&results, 1)
+	}
+
+	if _, err := watcher.Add("https://example.com/", 50*time.Millisecond, []string{"facebook"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&results) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if runs := atomic.LoadInt64(&runs); runs < 3 {
+		t.Errorf("expected URL to be collected repeatedly, got %d runs", runs)
+	}
+
+	reloaded, err := NewWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watches := reloaded.Watches()
+	if len(watches) != 1 || watches[0].Interval != 50*time.Millisecond || watches[0].Platforms[0] != "facebook" {
+		t.Fatalf("expected watchlist to survive restart, got %+v", watches)
+	}
+
+	if watches[0].LastResult == nil || watches[0].LastResult.Meta.Total != 7 || watches[0].LastRun.IsZero() {
+		t.Errorf("expected last result to be kept, got %+v", watches[0])
+	}
+}
+
+func TestWatcherBacksOffFailures(t *testing.T) {
+	watcher, _ := NewWatcher("")
+	watcher.Jitter = -1
+	watcher.MaxBackoff = time.Minute
+	watcher.Collect = func(ctx context.Context, lookupURL string, opts Options) (*Result, error) {
+		result := newResult(lookupURL)
+		result.Platforms["facebook"] = &PlatformResult{Name: "facebook", Error: errors.New("Blocked.")}
+		result.Errors = append(result.Errors, result.Platforms["facebook"].Error)
+		return result, nil
+	}
+
+	watcher.Add("https://example.com/", 10*time.Second, nil)
+	watch := watcher.watches["https://example.com/"]
+	for _, expected := range []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		watcher.run(context.Background(), watch)
+		if interval := watch.NextRun.Sub(watch.LastRun); interval != expected {
+			t.Errorf("expected next run in %s after %d failures, got %s", expected, watch.Failures, interval)
+		}
+	}
+
+	if watch.LastError != "Blocked." {
+		t.Errorf("expected last error to be kept, got %q", watch.LastError)
+	}
+
+	watcher.Jitter = 0.5
+	watch.Failures = 0
+	for i := 0; i < 20; i++ {
+		if interval := watcher.nextInterval(watch); interval < 5*time.Second || interval > 15*time.Second {
+			t.Errorf("expected jitter within half of interval, got %s", interval)
+		}
+	}
+}
+
+func TestWatcherKeepsChangesMadeWhileRunning(t *testing.T) {
+	watcher, _ := NewWatcher("")
+	watcher.Jitter = -1
+
+	started, release := make(chan string, 2), make(chan struct{})
+	var results int64
+	watcher.Collect = func(ctx context.Context, lookupURL string, opts Options) (*Result, error) {
+		started <- lookupURL
+		<-release
+		return newResult(lookupURL), nil
+	}
+	watcher.OnResult = func(watch *Watch, result *Result) {
+		atomic.AddInt64(&results, 1)
+	}
+
+	watcher.Add("https://example.com/added", time.Hour, nil)
+	watcher.Add("https://example.com/removed", time.Hour, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+
+	<-started
+	<-started
+	watcher.Add("https://example.com/added", time.Hour, nil)
+	watcher.Remove("https://example.com/removed")
+	release <- struct{}{}
+	release <- struct{}{}
+
+	select {
+	case lookupURL := <-started:
+		if lookupURL != "https://example.com/added" {
+			t.Errorf("expected re-added watch to run again, got %s", lookupURL)
+		}
+		release <- struct{}{}
+	case <-time.After(2 * time.Second):
+		t.Error("expected watch added while running to run right away")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&results) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if results := atomic.LoadInt64(&results); results != 2 {
+		t.Errorf("expected results only of the watch still watched, got %d", results)
+	}
+}
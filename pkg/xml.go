@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MarshalXML renders result as <stats> element with the same structure as
+// its JSON. Keys become elements and list items are rendered as <item>.
+func (r *Result) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name.Local = "stats"
+	return encodeXMLJSON(e, start, r)
+}
+
+// encodeXMLJSON renders JSON of value as start element.
+func encodeXMLJSON(e *xml.Encoder, start xml.StartElement, value interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return err
+	}
+
+	return encodeXMLValue(e, start, data)
+}
+
+func encodeXMLValue(e *xml.Encoder, start xml.StartElement, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			child := xml.StartElement{Name: xml.Name{Local: xmlName(key)}}
+			if err := encodeXMLValue(e, child, v[key]); err != nil {
+				return err
+			}
+		}
+
+		return e.EncodeToken(start.End())
+	case []interface{}:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+
+		for _, item := range v {
+			if err := encodeXMLValue(e, xml.StartElement{Name: xml.Name{Local: "item"}}, item); err != nil {
+				return err
+			}
+		}
+
+		return e.EncodeToken(start.End())
+	case nil:
+		return e.EncodeElement("", start)
+	default:
+		return e.EncodeElement(fmt.Sprint(v), start)
+	}
+}
+
+// xmlName turns key into valid XML element name.
+func xmlName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, key)
+
+	if name == "" || !(unicode.IsLetter(rune(name[0])) || name[0] == '_') {
+		name = "_" + name
+	}
+
+	return name
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var rateLimits = ""
+var rateLimitQueue = 10
+var rateLimiter *collector.RateLimiter
+
+// setupRateLimit builds limiter shared by all collections from flags.
+func setupRateLimit() error {
+	limits, err := parseRateLimits(rateLimits)
+	if err != nil {
+		return err
+	}
+
+	if len(limits) == 0 {
+		rateLimiter = nil
+		return nil
+	}
+
+	for name, limit := range limits {
+		limit.MaxQueue = rateLimitQueue
+		limits[name] = limit
+	}
+
+	rateLimiter = collector.NewRateLimiter(limits)
+	return nil
+}
+
+// parseRateLimits parses list of limits such as "facebook=1:5,*=0.5", where
+// each limit is requests per second optionally followed by burst.
+func parseRateLimits(value string) (map[string]collector.RateLimit, error) {
+	limits := map[string]collector.RateLimit{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("Invalid rate limit " + pair)
+		}
+
+		rate := strings.SplitN(parts[1], ":", 2)
+		rps, err := strconv.ParseFloat(rate[0], 64)
+		if err != nil || rps <= 0 {
+			return nil, errors.New("Invalid rate limit " + pair)
+		}
+
+		limit := collector.RateLimit{RPS: rps}
+		if len(rate) == 2 {
+			limit.Burst, err = strconv.Atoi(rate[1])
+			if err != nil || limit.Burst < 1 {
+				return nil, errors.New("Invalid rate limit " + pair)
+			}
+		}
+
+		limits[parts[0]] = limit
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var callbackPattern = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$.]*$`)
+
+type statsError struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Error   string   `json:"error" xml:",chardata"`
+}
+
+// negotiateFormat picks one of collector.Formats from "format" query
+// parameter, presence of "callback" or Accept header. Defaults to JSON.
+func negotiateFormat(r *http.Request) string {
+	query := r.URL.Query()
+	if format := strings.ToLower(query.Get("format")); format != "" {
+		if _, ok := collector.Formats[format]; ok {
+			return format
+		}
+	}
+
+	if query.Get("callback") != "" {
+		return "jsonp"
+	}
+
+	type accepted struct {
+		format string
+		q      float64
+	}
+
+	candidates := []accepted{}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+
+		if format := formatOfMediaType(mediaType); format != "" && q > 0 {
+			candidates = append(candidates, accepted{format, q})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	if len(candidates) > 0 {
+		return candidates[0].format
+	}
+
+	return "json"
+}
+
+func formatOfMediaType(mediaType string) string {
+	switch mediaType {
+	case "application/xml":
+		return "xml"
+	case "text/javascript", "application/x-javascript":
+		return "jsonp"
+	}
+
+	for format, formatType := range collector.Formats {
+		if formatType == mediaType {
+			return format
+		}
+	}
+
+	return ""
+}
+
+// render writes data in format negotiated for request.
+func render(w http.ResponseWriter, r *http.Request, data interface{}, code int) {
+	format := negotiateFormat(r)
+	w.Header().Set("Vary", "Accept")
+
+	var body []byte
+	var err error
+	switch format {
+	case "xml":
+		body, err = xml.Marshal(data)
+		body = append([]byte(xml.Header), body...)
+	case "jsonp":
+		callback := r.URL.Query().Get("callback")
+		if callback == "" {
+			callback = "callback"
+		}
+
+		if !callbackPattern.MatchString(callback) {
+			format, code = "json", http.StatusBadRequest
+			body, err = json.Marshal(statsError{Error: "Invalid callback."})
+			break
+		}
+
+		body, err = json.Marshal(data)
+		body = []byte("/**/" + callback + "(" + string(body) + ");")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	default:
+		body, err = json.Marshal(data)
+	}
+
+	if err != nil {
+		format, code = "json", http.StatusInternalServerError
+		body, _ = json.Marshal(statsError{Error: "Error compiling response."})
+	}
+
+	w.Header().Set("Content-Type", collector.Formats[format]+"; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// renderError writes error message in format negotiated for request.
+func renderError(w http.ResponseWriter, r *http.Request, error string, code int) {
+	render(w, r, statsError{Error: error}, code)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var retryAttempts = 1
+var retryBackoff = 200 * time.Millisecond
+var retryPlatformAttempts = ""
+var retryPolicy collector.RetryPolicy
+var platformRetry map[string]collector.RetryPolicy
+
+// setupRetry builds retry policies of platforms from flags.
+func setupRetry() error {
+	retryPolicy = collector.RetryPolicy{MaxAttempts: retryAttempts, Backoff: retryBackoff}
+
+	attempts, err := parsePlatformAttempts(retryPlatformAttempts)
+	if err != nil {
+		return err
+	}
+
+	platformRetry = map[string]collector.RetryPolicy{}
+	for name, maxAttempts := range attempts {
+		policy := retryPolicy
+		policy.MaxAttempts = maxAttempts
+		platformRetry[name] = policy
+	}
+
+	return nil
+}
+
+// parsePlatformAttempts parses list of attempts such as "pinterest=3,reddit=1".
+func parsePlatformAttempts(value string) (map[string]int, error) {
+	attempts := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid platform attempts " + pair)
+		}
+
+		maxAttempts, err := strconv.Atoi(parts[1])
+		if err != nil || maxAttempts < 1 {
+			return nil, errors.New("Invalid platform attempts " + pair)
+		}
+
+		attempts[parts[0]] = maxAttempts
+	}
+
+	return attempts, nil
+}
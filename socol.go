@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,42 +20,144 @@ import (
 )
 
 func statsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	start := time.Now()
-	query := r.URL.Query()
-	url := query.Get("url")
+	url := r.URL.Query().Get("url")
+	log := collector.LoggerFrom(r.Context()).With("url", url)
 
 	if url == "" {
 		error := "Missing required URL."
-		json, _ := json.Marshal(map[string]interface{}{"error": error})
-		http.Error(w, string(json), http.StatusBadRequest)
-		errorsLogger.Println("Failed", error)
+		renderError(w, r, error, http.StatusBadRequest)
+		log.Warn("Failed", "error", error)
 		return
 	}
 
+	aggregated, status, error := collectStats(r.Context(), url, statsOptions(r))
+	if cache != nil {
+		writeCacheHeaders(w, status)
+	}
+
+	if error != nil {
+		log.Warn("Cancelled stats", "error", error)
+		return
+	}
+
+	render(w, r, aggregated, http.StatusOK)
+	log.Info("Compiled stats", "duration", time.Now().Sub(start).Seconds(), "total", aggregated.Meta.Total)
+}
+
+// statsOptions builds collection options from query of request.
+func statsOptions(r *http.Request) collector.Options {
+	query := r.URL.Query()
 	platforms := strings.Split(query.Get("platforms"), ",")
 	if len(platforms) == 1 && platforms[0] == "" {
 		platforms = nil
 	}
 
-	privateProxy := query.Get("proxy")
+	return collector.Options{
+		Platforms:          platforms,
+		Proxy:              query.Get("proxy"),
+		InsecureSkipVerify: insecure,
+		CAFile:             caFile,
+		Canonicalize:       isTrue(query.Get("canonical")),
+		QueryVariants:      isTrue(query.Get("variants")),
+		MaxRedirects:       redirectsLimit(),
+		StripParams:        stripParamList(),
+		Retry:              retryPolicy,
+		PlatformRetry:      platformRetry,
+		RateLimiter:        rateLimiter,
+	}
+}
 
-	aggregated := collector.New(url, platforms, privateProxy)
+// stripParamList maps -strip-params to Options, where empty value keeps all
+// query parameters.
+func stripParamList() []string {
+	params := []string{}
+	for _, param := range strings.Split(stripParams, ",") {
+		if param = strings.TrimSpace(param); param != "" {
+			params = append(params, param)
+		}
+	}
 
-	body, error := json.Marshal(aggregated)
-	if error != nil {
-		error := "Error compiling JSON."
-		json, _ := json.Marshal(map[string]interface{}{"error": error})
-		http.Error(w, string(json), http.StatusInternalServerError)
-		return
+	return params
+}
+
+// redirectsLimit maps -max-redirects to Options, where 0 means default.
+func redirectsLimit() int {
+	if maxRedirects <= 0 {
+		return -1
+	}
+
+	return maxRedirects
+}
+
+func isTrue(value string) bool {
+	enabled, _ := strconv.ParseBool(value)
+	return enabled
+}
+
+// envBool reads boolean environment variable, which is false when unset.
+func envBool(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("Invalid value of " + name + ": " + value)
+	}
+
+	return enabled, nil
+}
+
+// collectStats collects stats through cache when it is enabled. Freshly
+// collected results are recorded to history and checked against rules.
+func collectStats(ctx context.Context, url string, opts collector.Options) (*collector.Result, collector.CacheStatus, error) {
+	if cache != nil {
+		result, status, error := cache.Collect(ctx, url, opts)
+		metrics.observeCache(status)
+		if error == nil && !status.Hit {
+			observeResult(result)
+		}
+
+		return result, status, error
+	}
+
+	result, error := collector.Collect(ctx, url, opts)
+	if error == nil {
+		observeResult(result)
 	}
 
-	logger.Println("Compiled stats for", url, "in", time.Now().Sub(start).Seconds(), "sec.")
-	w.Write(body)
+	return result, collector.CacheStatus{}, error
 }
 
-var logger *log.Logger
-var errorsLogger *log.Logger
+// withRequestID passes X-Request-ID of request, or a new one when it is
+// missing or invalid, to handler through context and echoes it in response.
+func withRequestID(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		handler(w, r.WithContext(collector.WithRequestID(r.Context(), id)))
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func writeJSONError(w http.ResponseWriter, error string, code int) {
+	json, _ := json.Marshal(map[string]interface{}{"error": error})
+	http.Error(w, string(json), code)
+}
+
+var logger *slog.Logger
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
 var isServer = false
 var cliURLs = []string{}
 var cliURL = ""
@@ -59,6 +165,10 @@ var cliPlatforms = []string{}
 var cliPlatform = ""
 var port = 5000
 var proxy = ""
+var insecure = false
+var caFile = ""
+var maxRedirects = 10
+var stripParams = strings.Join(collector.DefaultStripParams, ",")
 
 func init() {
 	if cpu := runtime.NumCPU(); cpu == 1 {
@@ -67,16 +177,57 @@ func init() {
 		runtime.GOMAXPROCS(cpu)
 	}
 
-	logger = log.New(os.Stdout, "socol-cmd ", log.Ldate|log.Ltime|log.Lshortfile)
-	errorsLogger = log.New(os.Stderr, "socol-cmd ", log.Ldate|log.Ltime|log.Lshortfile)
+	logger = collector.NewLogger(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	collector.Logger = logger
+}
+
+func fatal(message string, err error) {
+	logger.Error(message, "error", err)
+	os.Exit(1)
 }
 
 func main() {
+	insecureEnv, err := envBool("TLS_INSECURE")
+	if err != nil {
+		fatal("Error reading environment", err)
+	}
+
 	flag.BoolVar(&isServer, "s", false, "run as server")
 	flag.StringVar(&cliURL, "url", "", "url(s) to fetch")
 	flag.StringVar(&cliPlatform, "platform", "", "platform(s) to fetch")
+	flag.StringVar(&outputFormat, "o", "json", "output format: json, ndjson, csv or table")
+	flag.BoolVar(&canonicalize, "canonical", false, "query platforms for canonical URL of page")
+	flag.BoolVar(&queryVariants, "variants", false, "sum counts of http/https, www and trailing slash variants of URL")
+	flag.StringVar(&inputFile, "input", "", "file with one URL per line, - for stdin")
+	flag.IntVar(&concurrency, "concurrency", 1, "number of URLs collected at once")
+	flag.StringVar(&outputOrder, "order", "input", "print results in input or completion order")
+	flag.IntVar(&maxRedirects, "max-redirects", 10, "maximum number of redirects followed from URL, 0 follows none")
+	flag.StringVar(&stripParams, "strip-params", stripParams, "query parameters stripped from looked up URL, utm_* matches prefix, empty keeps all")
+	flag.IntVar(&retryAttempts, "retry-attempts", 1, "attempts of requests to platforms that time out or fail with 429 or 5xx")
+	flag.DurationVar(&retryBackoff, "retry-backoff", 200*time.Millisecond, "wait before second attempt, doubled for every next one")
+	flag.StringVar(&retryPlatformAttempts, "retry-platform-attempts", "", "per platform attempts, e.g. pinterest=3,reddit=1")
+	flag.StringVar(&rateLimits, "rate-limit", os.Getenv("RATE_LIMIT"), "requests per second and burst of platforms, e.g. facebook=1:5,*=2")
+	flag.IntVar(&rateLimitQueue, "rate-limit-queue", 10, "requests waiting for rate limit of platform before failing right away, negative for no limit")
 	flag.IntVar(&port, "p", 5000, "server port")
 	flag.StringVar(&proxy, "proxy", "", "proxy")
+	flag.BoolVar(&insecure, "insecure", insecureEnv, "skip TLS certificate verification")
+	flag.StringVar(&caFile, "ca-file", os.Getenv("TLS_CA_FILE"), "PEM file with additional trusted certificates")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 0, "time for which /stats results are cached, e.g. 5m")
+	flag.DurationVar(&cacheStale, "cache-stale", 0, "time for which expired results are served while refreshed")
+	flag.StringVar(&cachePlatformTTL, "cache-platform-ttl", "", "per platform cache TTLs, e.g. facebook=10m,reddit=1m")
+	flag.DurationVar(&cacheErrorTTL, "cache-error-ttl", 30*time.Second, "time for which results with timeouts, rate limits or 5xx are cached, negative disables it")
+	flag.IntVar(&batchWorkers, "batch-workers", 4, "number of URLs collected at once by /stats/batch")
+	flag.IntVar(&batchLimit, "batch-limit", 100, "maximum number of URLs accepted by /stats/batch")
+	flag.IntVar(&cacheSize, "cache-size", 1000, "number of results kept in memory cache")
+	flag.StringVar(&cacheDir, "cache-dir", "", "keep cached results in directory instead of memory")
+	flag.StringVar(&historyDir, "history-dir", os.Getenv("HISTORY_DIR"), "record snapshots of results in directory")
+	flag.StringVar(&watchFile, "watch-file", os.Getenv("WATCH_FILE"), "keep watchlist of periodically collected URLs in file")
+	flag.DurationVar(&watchMinInterval, "watch-min-interval", time.Minute, "shortest interval accepted by /watch")
+	flag.StringVar(&rulesFile, "rules-file", os.Getenv("RULES_FILE"), "JSON file with rules of webhook notifications")
+	flag.StringVar(&webhookURL, "webhook-url", os.Getenv("WEBHOOK_URL"), "webhook notified by rules without own webhook")
+	flag.StringVar(&webhookSecret, "webhook-secret", os.Getenv("WEBHOOK_SECRET"), "secret signing webhook notifications")
+	flag.BoolVar(&webhookDryRun, "webhook-dry-run", false, "log webhook notifications instead of sending them")
+	flag.StringVar(&historySince, "since", "", "show history since duration ago or RFC 3339 time")
 
 	proxyEnv := os.Getenv("PROXY")
 	if proxy == "" && proxyEnv != "" {
@@ -88,49 +239,82 @@ func main() {
 		proxy = proxyEnv
 	}
 
-	flag.Parse()
+	command := ""
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		command = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
-	if !isServer {
-		cliURLs = strings.Split(cliURL, ",")
-		cliPlatforms = strings.Split(cliPlatform, ",")
+	if err := setupRetry(); err != nil {
+		fatal("Error setting up retries", err)
+	}
 
-		for _, url := range cliURLs {
-			if len(cliPlatforms) == 0 ||
-				(len(cliPlatforms) == 1 && cliPlatforms[0] == "") {
-				cliPlatforms = nil
-			}
+	if err := setupRateLimit(); err != nil {
+		fatal("Error setting up rate limits", err)
+	}
+
+	if err := setupHistory(); err != nil {
+		fatal("Error setting up history", err)
+	}
 
-			aggregated := collector.New(url, cliPlatforms, proxy)
+	if command == "history" {
+		if err := runHistory(); err != nil {
+			fatal("Showing history failed", err)
+		}
 
-			body, error := json.MarshalIndent(aggregated, "", "  ")
-			if error != nil {
-				panic(error)
-			}
+		os.Exit(0)
+		return
+	}
 
-			fmt.Println(string(body))
+	if !isServer {
+		if err := runCLI(); err != nil {
+			fatal("Collecting stats failed", err)
 		}
 
 		os.Exit(0)
 		return
 	}
 
+	if ttl, err := time.ParseDuration(os.Getenv("CACHE_TTL")); err == nil && cacheTTL == 0 {
+		cacheTTL = ttl
+	}
+
+	if err := setupCache(); err != nil {
+		fatal("Error setting up cache", err)
+	}
+
+	if err := setupNotifier(); err != nil {
+		fatal("Error setting up notifications", err)
+	}
+
+	if err := setupWatcher(context.Background()); err != nil {
+		fatal("Error setting up watchlist", err)
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("socol."))
 	})
 
-	http.HandleFunc("/stats", statsHandler)
+	http.HandleFunc("/stats", withRequestID(instrument("stats", statsHandler)))
+	http.HandleFunc("/stats/batch", withRequestID(instrument("batch", batchHandler)))
+	http.HandleFunc("/stats/stream", withRequestID(instrument("stream", streamHandler)))
+	http.HandleFunc("/history", withRequestID(instrument("history", historyHandler)))
+	http.HandleFunc("/watch", withRequestID(instrument("watch", watchHandler)))
+	http.HandleFunc("/metrics", metricsHandler)
 
 	portAsString := os.Getenv("PORT")
 	if portAsString != "" {
 		port, _ = strconv.Atoi(portAsString)
 	}
 
-	logger.Println("Listening on", port)
+	logger.Info("Listening", "port", port)
 	error := http.ListenAndServe(":"+strconv.Itoa(port), nil)
 	if error != nil {
-		errorsLogger.Fatal("Error listening on ", port)
+		logger.Error("Error listening", "port", port, "error", error)
 		os.Exit(2)
 	} else {
-		logger.Println("Started.")
+		logger.Info("Started.")
 	}
 }
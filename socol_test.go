@@ -1,9 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
 )
 
 func TestMain(m *testing.M) {
@@ -18,3 +28,351 @@ func TestCollectStats(t *testing.T) {
 func TestCanRunPlatform(t *testing.T) {
 
 }
+
+func newOriginServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta property="og:title" content="Test page" /></head></html>`))
+	}))
+}
+
+func TestBatchHandler(t *testing.T) {
+	origin := newOriginServer()
+	defer origin.Close()
+
+	body := `{"urls": ["` + origin.URL + `/a", "` + origin.URL + `/b", "not a url"], "platforms": ["none"]}`
+	request := httptest.NewRequest("POST", "/stats/batch", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	batchHandler(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response map[string]map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response["results"]) != 2 {
+		t.Errorf("expected 2 results, got %v", response["results"])
+	}
+
+	if response["errors"]["not a url"] != "Invalid URL." {
+		t.Errorf("expected invalid URL error, got %v", response["errors"])
+	}
+}
+
+func TestBatchHandlerRejectsInvalidRequests(t *testing.T) {
+	for _, body := range []string{"", "[]", "{", `{"urls": "x"}`} {
+		request := httptest.NewRequest("POST", "/stats/batch", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		batchHandler(recorder, request)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %q, got %d", body, recorder.Code)
+		}
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	origin := newOriginServer()
+	defer origin.Close()
+
+	request := httptest.NewRequest("GET", "/stats/stream?platforms=none&url="+origin.URL, nil)
+	recorder := httptest.NewRecorder()
+	streamHandler(recorder, request)
+
+	if recorder.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("expected event stream, got %q", recorder.Header().Get("Content-Type"))
+	}
+
+	body := recorder.Body.String()
+	originAt, metaAt := strings.Index(body, "event: origin\n"), strings.Index(body, "event: meta\n")
+	if originAt == -1 || metaAt == -1 || originAt > metaAt {
+		t.Errorf("expected origin and meta events, got %q", body)
+	}
+}
+
+func TestStreamHandlerRecordsHistory(t *testing.T) {
+	origin := newOriginServer()
+	defer origin.Close()
+
+	dir, err := ioutil.TempDir("", "socol-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	historyDir = dir
+	defer func() { historyDir, history = "", nil }()
+	if err := setupHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	streamHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/stats/stream?platforms=none&url="+origin.URL, nil))
+
+	snapshots, err := history.Snapshots(origin.URL, time.Time{})
+	if err != nil || len(snapshots) != 1 {
+		t.Errorf("expected streamed result to be recorded, got %d snapshots: %v", len(snapshots), err)
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		url    string
+		accept string
+		format string
+	}{
+		{"/stats", "", "json"},
+		{"/stats?format=xml", "application/json", "xml"},
+		{"/stats?callback=cb", "", "jsonp"},
+		{"/stats", "text/xml", "xml"},
+		{"/stats", "application/json;q=0.5, application/xml", "xml"},
+		{"/stats", "application/javascript", "jsonp"},
+		{"/stats", "text/html, */*", "json"},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest("GET", c.url, nil)
+		request.Header.Set("Accept", c.accept)
+		if format := negotiateFormat(request); format != c.format {
+			t.Errorf("expected %s for %s with %q, got %s", c.format, c.url, c.accept, format)
+		}
+	}
+}
+
+func TestStatsHandlerFormats(t *testing.T) {
+	origin := newOriginServer()
+	defer origin.Close()
+
+	cases := map[string]string{
+		"format=xml":         "<total>0</total></meta><origin><Title>Test page</Title>",
+		"callback=widget.cb": "/**/widget.cb({",
+		"callback=alert(1)":  `{"error":"Invalid callback."}`,
+	}
+
+	for query, prefix := range cases {
+		request := httptest.NewRequest("GET", "/stats?platforms=none&url="+origin.URL+"&"+query, nil)
+		recorder := httptest.NewRecorder()
+		statsHandler(recorder, request)
+
+		if body := recorder.Body.String(); !strings.Contains(body, prefix) {
+			t.Errorf("expected %q in response to %s, got %q", prefix, query, body)
+		}
+	}
+}
+
+func TestResultWriters(t *testing.T) {
+	result := &collector.Result{
+		Platforms: map[string]*collector.PlatformResult{
+			"facebook": {Name: "facebook", Count: 10},
+			"reddit":   {Name: "reddit", Error: errors.New("Failed.")},
+		},
+		Meta: collector.Meta{Total: 10},
+	}
+
+	cases := map[string]string{
+		"csv":    "url,facebook,reddit,total\nhttps://golang.org/,10,,10\n",
+		"ndjson": `"url":"https://golang.org/"`,
+		"table":  "facebook  reddit  total",
+	}
+
+	for format, expected := range cases {
+		var out bytes.Buffer
+		writer, err := newResultWriter(&out, format, []string{"facebook", "reddit"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writer.Write("https://golang.org/", result)
+		writer.Flush()
+
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in %s output, got %q", expected, format, out.String())
+		}
+	}
+
+	if _, err := newResultWriter(&bytes.Buffer{}, "yaml", nil); err == nil {
+		t.Error("expected unknown format to be rejected")
+	}
+}
+
+func TestReadURLs(t *testing.T) {
+	input := "# popular\nhttps://golang.org/\n\n  https://example.com/?a=1,2  \n#https://skipped.com/\n"
+	urls, err := readURLs(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(urls) != 2 || urls[0] != "https://golang.org/" || urls[1] != "https://example.com/?a=1,2" {
+		t.Errorf("unexpected URLs %v", urls)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	origin := newOriginServer()
+	defer origin.Close()
+
+	dir, err := ioutil.TempDir("", "socol-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	historyDir = dir
+	defer func() { historyDir, history = "", nil }()
+	if err := setupHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		statsHandler(recorder, httptest.NewRequest("GET", "/stats?platforms=none&url="+origin.URL, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	historyHandler(recorder, httptest.NewRequest("GET", "/history?since=1h&url="+origin.URL, nil))
+
+	var series collector.History
+	if err := json.Unmarshal(recorder.Body.Bytes(), &series); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Code != http.StatusOK || len(series.Points) != 2 {
+		t.Errorf("expected 2 points, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var output bytes.Buffer
+	if err := writeHistory(&output, "csv", &series); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(output.String()), "\n"); len(lines) != 3 || lines[0] != "time,total,total_delta" {
+		t.Errorf("unexpected CSV %q", output.String())
+	}
+}
+
+func TestWatchHandler(t *testing.T) {
+	var err error
+	watcher, err = collector.NewWatcher("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { watcher = nil }()
+
+	for body, code := range map[string]int{
+		`{"url": "https://golang.org/", "interval": "2h", "platforms": ["facebook"]}`: http.StatusCreated,
+		`{"url": "https://golang.org/", "interval": "1s"}`:                            http.StatusBadRequest,
+		`{"url": "not a url"}`: http.StatusBadRequest,
+		`{"url":`:              http.StatusBadRequest,
+	} {
+		recorder := httptest.NewRecorder()
+		watchHandler(recorder, httptest.NewRequest("POST", "/watch", strings.NewReader(body)))
+		if recorder.Code != code {
+			t.Errorf("expected %d for %s, got %d: %s", code, body, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	watchHandler(recorder, httptest.NewRequest("GET", "/watch", nil))
+
+	var response struct {
+		Watches []*collector.Watch `json:"watches"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Watches) != 1 || response.Watches[0].Interval != 2*time.Hour || response.Watches[0].NextRun.IsZero() {
+		t.Errorf("unexpected watches %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	watchHandler(recorder, httptest.NewRequest("DELETE", "/watch?url=https://golang.org/", nil))
+	if recorder.Code != http.StatusNoContent || len(watcher.Watches()) != 0 {
+		t.Errorf("expected watch to be removed, got %d", recorder.Code)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	metrics = newServerMetrics()
+	defer func() { metrics = newServerMetrics() }()
+
+	recorder := httptest.NewRecorder()
+	instrument("stats", statsHandler)(recorder, httptest.NewRequest("GET", "/stats", nil))
+
+	result := &collector.Result{
+		Platforms: map[string]*collector.PlatformResult{
+			"facebook": {Name: "facebook", Count: 1, FetchedIn: 300 * time.Millisecond, CompletedIn: 400 * time.Millisecond},
+			"reddit": {Name: "reddit", Error: &collector.PlatformError{
+				Platform:   "reddit",
+				Kind:       collector.ErrorHTTPStatus,
+				StatusCode: 429,
+				Err:        errors.New("Got non OK HTTP status at 429 Too Many Requests-x"),
+			}},
+		},
+		Origin: &collector.Origin{FetchedIn: 2 * time.Second},
+	}
+	metrics.observeResult(result)
+	metrics.observeCache(collector.CacheStatus{Hit: true})
+	metrics.observeCache(collector.CacheStatus{})
+
+	recorder = httptest.NewRecorder()
+	metricsHandler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`socol_http_requests_in_flight 0`,
+		`socol_http_requests_total{handler="stats",code="400"} 1`,
+		`socol_platform_requests_total{platform="reddit"} 1`,
+		`socol_platform_errors_total{platform="reddit",class="http_status"} 1`,
+		`socol_platform_fetch_seconds_bucket{platform="facebook",le="0.25"} 0`,
+		`socol_platform_fetch_seconds_bucket{platform="facebook",le="0.5"} 1`,
+		`socol_platform_fetch_seconds_count{platform="facebook"} 1`,
+		`socol_origin_fetch_seconds_bucket{le="2.5"} 1`,
+		`socol_origin_fetch_seconds_sum 2`,
+		`socol_cache_hit_ratio 0.5`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %s in metrics", line)
+		}
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(func(w http.ResponseWriter, r *http.Request) {
+		seen = collector.RequestID(r.Context())
+	})
+
+	request := httptest.NewRequest("GET", "/stats", nil)
+	request.Header.Set("X-Request-ID", "trace-1")
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if seen != "trace-1" || recorder.Header().Get("X-Request-ID") != "trace-1" {
+		t.Errorf("expected request ID to be passed, got %q", seen)
+	}
+
+	request.Header.Set("X-Request-ID", "bad id\n")
+	recorder = httptest.NewRecorder()
+	handler(recorder, request)
+	if len(seen) != 16 || recorder.Header().Get("X-Request-ID") != seen {
+		t.Errorf("expected new request ID, got %q", seen)
+	}
+}
+
+func TestEnvBool(t *testing.T) {
+	for value, expected := range map[string]bool{"": false, "0": false, "false": false, "1": true, "true": true} {
+		os.Setenv("SOCOL_TEST_BOOL", value)
+		if enabled, err := envBool("SOCOL_TEST_BOOL"); err != nil || enabled != expected {
+			t.Errorf("expected %q to be %v, got %v %v", value, expected, enabled, err)
+		}
+	}
+
+	os.Setenv("SOCOL_TEST_BOOL", "yes")
+	defer os.Unsetenv("SOCOL_TEST_BOOL")
+
+	if _, err := envBool("SOCOL_TEST_BOOL"); err == nil {
+		t.Error("expected invalid value to fail")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+type platformEvent struct {
+	Name  string                    `json:"name"`
+	Stats *collector.PlatformResult `json:"stats,omitempty"`
+	Error string                    `json:"error,omitempty"`
+}
+
+// streamHandler sends stats over Server-Sent Events. Origin is sent as
+// "origin" event, each platform as "platform" event once it completes and
+// aggregated result as final "meta" event.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	url := r.URL.Query().Get("url")
+	log := collector.LoggerFrom(r.Context()).With("url", url)
+
+	if url == "" {
+		error := "Missing required URL."
+		writeJSONError(w, error, http.StatusBadRequest)
+		log.Warn("Failed", "error", error)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	opts := statsOptions(r)
+	for event := range collector.Stream(r.Context(), url, opts) {
+		var name string
+		var data interface{}
+
+		switch {
+		case event.Origin != nil:
+			name, data = "origin", event.Origin
+		case event.Platform != nil:
+			stats := platformEvent{Name: event.Platform.Name}
+			if event.Platform.Error != nil {
+				stats.Error = event.Platform.Error.Error()
+			} else {
+				stats.Stats = event.Platform
+			}
+			name, data = "platform", stats
+		case event.Err != nil:
+			log.Warn("Cancelled stats stream", "error", event.Err)
+			return
+		default:
+			observeResult(event.Result)
+			if cache != nil {
+				cache.Store(url, opts, event.Result)
+			}
+			name, data = "meta", event.Result
+		}
+
+		if err := writeEvent(w, name, data); err != nil {
+			log.Warn("Failed writing event", "error", err)
+			return
+		}
+		flusher.Flush()
+	}
+
+	log.Info("Streamed stats", "duration", time.Now().Sub(start).Seconds())
+}
+
+func writeEvent(w http.ResponseWriter, name string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, body)
+	return err
+}
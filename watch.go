@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/otobrglez/socol/pkg"
+)
+
+var watcher *collector.Watcher
+var watchFile = ""
+var watchMinInterval = time.Minute
+
+type watchRequest struct {
+	URL       string   `json:"url"`
+	Interval  string   `json:"interval"`
+	Platforms []string `json:"platforms"`
+}
+
+// setupWatcher loads watchlist and starts collecting watched URLs when
+// watchlist file is set.
+func setupWatcher(ctx context.Context) error {
+	if watchFile == "" {
+		return nil
+	}
+
+	w, err := collector.NewWatcher(watchFile)
+	if err != nil {
+		return err
+	}
+
+	w.Options = collector.Options{
+		Proxy:              proxy,
+		InsecureSkipVerify: insecure,
+		CAFile:             caFile,
+		MaxRedirects:       redirectsLimit(),
+		StripParams:        stripParamList(),
+		Retry:              retryPolicy,
+		PlatformRetry:      platformRetry,
+		RateLimiter:        rateLimiter,
+	}
+
+	w.OnResult = func(watch *collector.Watch, result *collector.Result) {
+		observeResult(result)
+	}
+
+	watcher = w
+	go watcher.Run(ctx)
+	return nil
+}
+
+// watchHandler lists watched URLs on GET, adds URL posted as
+// {"url", "interval", "platforms"} on POST and stops watching URL given by
+// "url" query parameter on DELETE.
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if watcher == nil {
+		writeJSONError(w, "Watchlist is not enabled.", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, map[string]interface{}{"watches": watcher.Watches()}, http.StatusOK)
+	case "POST":
+		request, interval, err := readWatchRequest(w, r)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		watch, err := watcher.Add(request.URL, interval, request.Platforms)
+		if err != nil {
+			logger.Error("Saving watchlist failed", "error", err)
+			writeJSONError(w, "Error saving watchlist.", http.StatusInternalServerError)
+			return
+		}
+
+		collector.LoggerFrom(r.Context()).Info("Watching", "url", request.URL, "interval", interval.String())
+		writeJSON(w, watch, http.StatusCreated)
+	case "DELETE":
+		removed, err := watcher.Remove(r.URL.Query().Get("url"))
+		if err != nil {
+			logger.Error("Saving watchlist failed", "error", err)
+			writeJSONError(w, "Error saving watchlist.", http.StatusInternalServerError)
+			return
+		}
+
+		if !removed {
+			writeJSONError(w, "URL is not watched.", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, "Only GET, POST and DELETE are supported.", http.StatusMethodNotAllowed)
+	}
+}
+
+// readWatchRequest reads watch posted as JSON. Interval defaults to an hour.
+func readWatchRequest(w http.ResponseWriter, r *http.Request) (watchRequest, time.Duration, error) {
+	request := watchRequest{}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return request, 0, err
+	}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		return request, 0, errors.New("Invalid JSON.")
+	}
+
+	if !isHTTPURL(request.URL) {
+		return request, 0, errors.New("Invalid URL.")
+	}
+
+	interval := time.Hour
+	if request.Interval != "" {
+		interval, err = time.ParseDuration(request.Interval)
+		if err != nil {
+			return request, 0, errors.New("Invalid interval.")
+		}
+	}
+
+	if interval < watchMinInterval {
+		return request, 0, errors.New("Interval must be at least " + watchMinInterval.String() + ".")
+	}
+
+	return request, interval, nil
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}, code int) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		writeJSONError(w, "Error compiling JSON.", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(code)
+	w.Write(body)
+}